@@ -0,0 +1,180 @@
+package invertedindex
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// archiveSeparator separates the path of an archive from the name of one of
+// its members in the path of a document, e.g. bundle.zip!/docs/a.txt
+const archiveSeparator = "!/"
+
+// errStopWalk is returned by the function passed to walkArchive to stop
+// walking early
+var errStopWalk = errors.New("stop walking archive")
+
+// isArchive reports whether the file at path is named like an archive whose
+// members can be indexed
+func isArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// readArchive indexes every regular file in the archive at path as a
+// document of its own. Archives within the archive are read in turn.
+// Members larger than the MaxFileSize flag are skipped before they are
+// decompressed, going by the size their header gives, and reading one stops
+// as soon as it proves larger than its header said.
+func (i *Indexer) readArchive(path string, contents []byte) {
+	err := walkArchive(path, contents, func(name string, size int64, r io.Reader) error {
+		if i.flags.Verbose {
+			fmt.Printf("Reading archive member: %s\n", name)
+		}
+		if i.tooLarge(path+archiveSeparator+name, size) {
+			return nil
+		}
+		data, err := readLimited(r, i.flags.MaxFileSize)
+		if err != nil {
+			return err
+		}
+		if i.tooLarge(path+archiveSeparator+name, int64(len(data))) {
+			return nil
+		}
+		i.indexFile(path+archiveSeparator+name, data)
+		return nil
+	})
+	if err != nil {
+		if i.flags.Abort {
+			fmt.Printf("%s: %s\n", path, err)
+			i.cleanup()
+			os.Exit(1)
+		}
+		i.skipFile(path, err.Error())
+	}
+}
+
+// readLimited reads r to its end, or if limit is positive to at most one
+// byte past limit, which is enough to tell the contents are too large
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// walkArchive calls fn with the name, the size as stored in its header and
+// a reader of the contents of every regular file in the archive at path, in
+// the order they are stored, until fn returns an error. Names use forward
+// slashes and have no leading slash. The reader is only valid until fn
+// returns.
+func walkArchive(path string, contents []byte, fn func(name string, size int64, r io.Reader) error) error {
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".zip") {
+		return walkZip(contents, fn)
+	}
+	r := io.Reader(bytes.NewReader(contents))
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return walkTar(r, fn)
+}
+
+func walkZip(contents []byte, fn func(name string, size int64, r io.Reader) error) error {
+	z, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return err
+	}
+	for _, file := range z.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(memberName(file.Name), int64(file.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, fn func(name string, size int64, r io.Reader) error) error {
+	t := tar.NewReader(r)
+	for {
+		header, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(memberName(header.Name), header.Size, t); err != nil {
+			return err
+		}
+	}
+}
+
+// memberName cleans the name of an archive member
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// readDocumentFile returns the contents of the file at path, which may be a
+// member of an archive, or of an archive within an archive, as named by
+// readArchive
+func readDocumentFile(path string) ([]byte, error) {
+	parts := strings.Split(path, archiveSeparator)
+	contents, err := ioutil.ReadFile(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	archive := parts[0]
+	for _, member := range parts[1:] {
+		var found []byte
+		err := walkArchive(archive, contents, func(name string, size int64, r io.Reader) error {
+			if name != member {
+				return nil
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			found = data
+			return errStopWalk
+		})
+		if err != errStopWalk {
+			if err == nil {
+				err = fmt.Errorf("%s: no member named %s", archive, member)
+			}
+			return nil, err
+		}
+		archive += archiveSeparator + member
+		contents = found
+	}
+	return contents, nil
+}
@@ -0,0 +1,71 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Tests for indexing the members of archives as documents of their own
+
+var archivepath string = "test_files/archive_files"
+
+func TestCrawlArchives(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true}, archivepath)
+	tgz := filepath.Join(archivepath, "bundle.tar.gz")
+	zip := filepath.Join(archivepath, "bundle.zip")
+	expected := map[int]string{
+		0: tgz + "!/docs/c.txt",
+		1: tgz + "!/nested.zip!/d.txt",
+		2: zip + "!/docs/a.txt",
+		3: zip + "!/b.txt",
+		4: filepath.Join(archivepath, "plain.tar") + "!/e.txt",
+		5: filepath.Join(archivepath, "readme.txt"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestCrawlArchivesDisabled(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, archivepath)
+	// the archives themselves are skipped as binary files
+	expected := map[int]string{0: filepath.Join(archivepath, "readme.txt")}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+	if skipped := len(indexer.Report().Skipped); skipped != 3 {
+		t.Errorf("Expected number of files skipped: 3, actual: %d", skipped)
+	}
+	assertSearchResult(t, indexer, "alpha", []int{})
+}
+
+func TestCrawlSingleArchive(t *testing.T) {
+	zip := filepath.Join(archivepath, "bundle.zip")
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true}, zip)
+	expected := map[int]string{0: zip + "!/docs/a.txt", 1: zip + "!/b.txt"}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestSearchArchiveMembers(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true}, archivepath)
+	assertSearchResult(t, indexer, "zip", []int{2, 3})
+	assertSearchResult(t, indexer, "delta nested", []int{1})
+	assertSearchResult(t, indexer, "path:bundle.zip", []int{2, 3})
+	assertSearchResult(t, indexer, "path:docs", []int{0, 2})
+	assertSearchResult(t, indexer, "name:d", []int{1})
+}
+
+func TestSnippetsOfArchiveMembers(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true}, archivepath)
+	snippets, err := indexer.Snippets("nested", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{"delta [nested]\n"})
+}
+
+func TestReadMissingArchiveMember(t *testing.T) {
+	zip := filepath.Join(archivepath, "bundle.zip")
+	if _, err := readDocumentFile(zip + "!/missing.txt"); err == nil {
+		t.Error("expected error reading missing archive member")
+	}
+	if _, err := readDocumentFile(zip + "!/b.txt!/c.txt"); err == nil {
+		t.Error("expected error reading member of a file that is not an archive")
+	}
+}
@@ -0,0 +1,199 @@
+package invertedindex
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default numbers of entries kept by the caches of an index, see
+// Indexer.SetCacheSizes
+const (
+	DefaultPostingCacheSize = 4096
+	DefaultQueryCacheSize   = 256
+)
+
+// lruCache holds up to capacity values, evicting the least recently used
+// one to make room for another. It is safe for concurrent use. A nil cache,
+// or one of no capacity, holds nothing.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	// entries, most recently used first, and key -> element of entries
+	entries      *list.List
+	elements     map[interface{}]*list.Element
+	hits, misses int
+}
+
+type lruEntry struct {
+	key, value interface{}
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		entries:  list.New(),
+		elements: make(map[interface{}]*list.Element),
+	}
+}
+
+// get returns the value cached under key, marking it as the most recently
+// used
+func (c *lruCache) get(key interface{}) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.elements[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.entries.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// add caches value under key, evicting the least recently used values if
+// the cache is full
+func (c *lruCache) add(key, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity <= 0 {
+		return
+	}
+	if e, ok := c.elements[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.entries.MoveToFront(e)
+		return
+	}
+	c.elements[key] = c.entries.PushFront(&lruEntry{key, value})
+	c.evict()
+}
+
+// evict removes the least recently used values until the cache is within
+// its capacity; c.mu must be held
+func (c *lruCache) evict() {
+	for c.entries.Len() > c.capacity && c.entries.Len() > 0 {
+		e := c.entries.Back()
+		c.entries.Remove(e)
+		delete(c.elements, e.Value.(*lruEntry).key)
+	}
+}
+
+// resize changes the capacity of the cache, evicting values if it shrinks
+func (c *lruCache) resize(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capacity = capacity
+	c.evict()
+}
+
+// clear empties the cache and its counts of hits and misses
+func (c *lruCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries.Init()
+	c.elements = make(map[interface{}]*list.Element)
+	c.hits, c.misses = 0, 0
+}
+
+// len returns the number of values cached
+func (c *lruCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// stats returns how many lookups of the cache hit and missed
+func (c *lruCache) stats() (hits, misses int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// CacheStats counts the lookups of the caches of an index that found what
+// they were after, and those that did not
+type CacheStats struct {
+	PostingHits   int `json:"postingHits"`
+	PostingMisses int `json:"postingMisses"`
+	QueryHits     int `json:"queryHits"`
+	QueryMisses   int `json:"queryMisses"`
+}
+
+// postingKey names the posting list of a term of a field in the posting
+// cache of an index
+type postingKey struct {
+	f    *field
+	term string
+}
+
+// postingList is a decoded posting list, as held by the posting cache
+type postingList struct {
+	docIDs      []int
+	occurrences map[int][]occurrence
+}
+
+// SetCacheSizes sets how many decoded posting lists and how many query
+// results the index keeps in its caches. Posting lists are only cached for
+// an index read from disk, one opened with OpenIndex or built with the
+// IndexDir flag, as those of an index built in memory are never decoded.
+// Queries are cached by their normalized form, so that "foo  bar" and
+// "bar foo" share a result, and both caches are emptied whenever the index
+// is rebuilt or reopened. A size of 0 disables a cache.
+func (i *Indexer) SetCacheSizes(postings, queries int) {
+	if i.postingCache == nil {
+		i.postingCache = newLRUCache(postings)
+		i.queryCache = newLRUCache(queries)
+		return
+	}
+	i.postingCache.resize(postings)
+	i.queryCache.resize(queries)
+}
+
+// CacheStats returns the hits and misses of the caches of the index since
+// it was built or opened
+func (i *Indexer) CacheStats() CacheStats {
+	var stats CacheStats
+	stats.PostingHits, stats.PostingMisses = i.postingCache.stats()
+	stats.QueryHits, stats.QueryMisses = i.queryCache.stats()
+	return stats
+}
+
+// invalidate starts a new generation of the index, which is about to be
+// rebuilt or reopened, emptying its caches
+func (i *Indexer) invalidate() {
+	i.generation++
+	if i.postingCache == nil {
+		i.SetCacheSizes(DefaultPostingCacheSize, DefaultQueryCacheSize)
+	}
+	i.postingCache.clear()
+	i.queryCache.clear()
+}
+
+// queryKey returns the key a query's results are cached under: the kind of
+// search, the generation of the index and the query's clauses in a
+// canonical form, sorted, since their order does not change the result
+func (i *Indexer) queryKey(kind string, clauses []fieldClause, fields []*field) string {
+	parts := make([]string, len(clauses))
+	for k, c := range clauses {
+		parts[k] = c.describe(fields[k])
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%s %d %s", kind, i.generation, strings.Join(parts, " "))
+}
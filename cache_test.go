@@ -0,0 +1,137 @@
+package invertedindex
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for caching posting lists and query results
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+	c.get("a")
+	// b is now the least recently used
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for key, expected := range map[string]int{"a": 1, "c": 3} {
+		if value, ok := c.get(key); !ok || value != expected {
+			t.Errorf("Expected %s to be cached as %d, actual: %v %v", key, expected, value, ok)
+		}
+	}
+	c.add("a", 4)
+	if value, _ := c.get("a"); value != 4 || c.len() != 2 {
+		t.Errorf("Expected a to be replaced, actual: %v, %d values", value, c.len())
+	}
+	if hits, misses := c.stats(); hits != 4 || misses != 1 {
+		t.Errorf("Expected 4 hits and 1 miss, actual: %d %d", hits, misses)
+	}
+	c.resize(1)
+	if _, ok := c.get("c"); ok || c.len() != 1 {
+		t.Errorf("Expected shrinking to evict c, actual: %d values", c.len())
+	}
+	c.clear()
+	if hits, misses := c.stats(); c.len() != 0 || hits != 0 || misses != 0 {
+		t.Error("Expected clearing to empty the cache")
+	}
+}
+
+func TestLRUCacheDisabled(t *testing.T) {
+	var nilCache *lruCache
+	nilCache.add("a", 1)
+	if _, ok := nilCache.get("a"); ok || nilCache.len() != 0 {
+		t.Error("Expected a nil cache to hold nothing")
+	}
+	c := newLRUCache(0)
+	c.add("a", 1)
+	if _, ok := c.get("a"); ok {
+		t.Error("Expected a cache of no capacity to hold nothing")
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	assertSearchResult(t, indexer, "alpha beta", []int{1, 2})
+	// the same clauses in another order, or spaced otherwise, are the same
+	// query, as are terms differing only in case in a case folded field
+	assertSearchResult(t, indexer, "beta  alpha", []int{1, 2})
+	assertSearchResult(t, indexer, "name:unique", []int{2})
+	assertSearchResult(t, indexer, "name:UNIQUE", []int{2})
+	assertSearchResult(t, indexer, "Alpha", []int{})
+	if stats := indexer.CacheStats(); stats.QueryHits != 2 || stats.QueryMisses != 3 {
+		t.Errorf("Expected 2 query hits and 3 misses, actual: %+v", stats)
+	}
+
+	// results handed out can be modified without changing those cached
+	result, _ := indexer.Search("alpha")
+	result[0] = 42
+	assertSearchResult(t, indexer, "alpha", []int{0, 1, 2})
+
+	indexer.RankedSearch("gamma")
+	hits, _ := indexer.RankedSearch("gamma")
+	if stats := indexer.CacheStats(); stats.QueryHits != 4 || len(hits) != 2 {
+		t.Errorf("Expected ranked searches to be cached, actual: %+v %v", stats, hits)
+	}
+}
+
+func TestQueryCacheInvalidated(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	assertSearchResult(t, indexer, "gamma", []int{1, 2})
+	// docIDs carry on from those of the earlier build
+	indexer.BuildIndex(IndexerFlags{}, filepath.Join(indexpath, "multi"))
+	assertSearchResult(t, indexer, "gamma", []int{4, 5})
+	assertSearchResult(t, indexer, "epsilon", []int{5})
+	if stats := indexer.CacheStats(); stats.QueryHits != 0 {
+		t.Errorf("Expected rebuilding to empty the cache, actual: %+v", stats)
+	}
+
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	onDisk := setUpIndexer(t, IndexerFlags{IndexDir: dir}, indexpath)
+	defer onDisk.Close()
+	assertSearchResult(t, onDisk, "epsilon", []int{})
+	generation := onDisk.generation
+	onDisk.BuildIndex(IndexerFlags{IndexDir: dir}, filepath.Join(indexpath, "multi"))
+	if onDisk.generation == generation {
+		t.Error("Expected a new generation of the index")
+	}
+	assertSearchResult(t, onDisk, "epsilon", []int{5})
+}
+
+func TestPostingCache(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{IndexDir: dir}, indexpath).Close()
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	opened.SetCacheSizes(1, 0)
+	assertSearchResult(t, opened, "alpha delta", []int{1})
+	assertSearchResult(t, opened, "alpha delta", []int{1})
+	assertSearchResult(t, opened, `"alpha beta"`, []int{1, 2})
+	if n := opened.postingCache.len(); n != 1 {
+		t.Errorf("Expected the posting cache to hold 1 term, actual: %d", n)
+	}
+	if stats := opened.CacheStats(); stats.QueryHits != 0 || stats.PostingMisses == 0 {
+		t.Errorf("Expected no query to be cached, actual: %+v", stats)
+	}
+
+	opened.SetCacheSizes(DefaultPostingCacheSize, 0)
+	assertSearchResult(t, opened, `"alpha beta"`, []int{1, 2})
+	before := opened.CacheStats()
+	assertSearchResult(t, opened, `"alpha beta"`, []int{1, 2})
+	after := opened.CacheStats()
+	if after.PostingMisses != before.PostingMisses || after.PostingHits <= before.PostingHits {
+		t.Errorf("Expected hot terms to be taken from the cache, actual: %+v then %+v", before, after)
+	}
+	if !reflect.DeepEqual(opened.fields[bodyField].postings, map[string][]int{}) {
+		t.Error("Expected no postings to be kept outside the cache")
+	}
+}
@@ -0,0 +1,331 @@
+package invertedindex
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An index on disk is written as a series of commits. The files of a commit
+// go to a directory of their own named after its generation, each written
+// under a temporary name and synced before being renamed, and the commit
+// only takes effect once its manifest, listing the files and their
+// checksums, is in turn written, synced and renamed into place. A crash at
+// any point thus leaves the previous commit as it was. Opening an index
+// reads the latest commit whose files match their manifest.
+const (
+	manifestPrefix = "commit-"
+	manifestSuffix = ".json"
+	tempSuffix     = ".tmp"
+)
+
+// checksumBlockSize is the size of the blocks of a file that have a
+// checksum of their own, so that a block can be checked when first read
+// rather than the whole file up front
+const checksumBlockSize = 256 << 10
+
+// commitsKept is how many commits are kept in an index directory: the latest
+// and the ones it can be rolled back to
+const commitsKept = 2
+
+// manifest lists the files of a commit
+type manifest struct {
+	Generation int            `json:"generation"`
+	BlockSize  int            `json:"blockSize"`
+	Files      []fileChecksum `json:"files"`
+	// CRC32 of the manifest written with a zero checksum
+	Checksum uint32 `json:"checksum"`
+}
+
+// fileChecksum is the size and CRC32 of a file of a commit, along with the
+// CRC32 of each of its blocks
+type fileChecksum struct {
+	Name   string   `json:"name"`
+	Size   int64    `json:"size"`
+	CRC32  uint32   `json:"crc32"`
+	Blocks []uint32 `json:"blocks"`
+}
+
+// sum returns the checksum of the manifest
+func (m manifest) sum() uint32 {
+	m.Checksum = 0
+	contents, _ := json.Marshal(m)
+	return crc32.ChecksumIEEE(contents)
+}
+
+// file returns the checksums of the named file, and whether the manifest
+// lists it
+func (m manifest) file(name string) (fileChecksum, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return fileChecksum{}, false
+}
+
+func generationDir(dir string, generation int) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d", generation))
+}
+
+func manifestPath(dir string, generation int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%06d%s", manifestPrefix, generation, manifestSuffix))
+}
+
+// commitGenerations returns the generations of the commits of the index in
+// dir, latest first
+func commitGenerations(dir string) ([]int, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	generations := []int{}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, manifestPrefix) || !strings.HasSuffix(name, manifestSuffix) {
+			continue
+		}
+		number := strings.TrimSuffix(strings.TrimPrefix(name, manifestPrefix), manifestSuffix)
+		if generation, err := strconv.Atoi(number); err == nil && generation > 0 {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(generations)))
+	return generations, nil
+}
+
+// readManifest reads the manifest of the commit of the passed generation
+// and checks it is whole
+func readManifest(dir string, generation int) (manifest, error) {
+	var m manifest
+	contents, err := ioutil.ReadFile(manifestPath(dir, generation))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(contents, &m); err != nil {
+		return m, err
+	}
+	if m.Checksum != m.sum() || m.Generation != generation || m.BlockSize <= 0 {
+		return m, errCorrupt
+	}
+	return m, nil
+}
+
+// removeStaleCommits removes from the index in dir the commits older than
+// those kept, along with whatever commits never completed left behind
+func removeStaleCommits(dir string) {
+	generations, err := commitGenerations(dir)
+	if err != nil {
+		return
+	}
+	kept := make(map[string]bool)
+	for k, generation := range generations {
+		if k < commitsKept {
+			kept[filepath.Base(generationDir(dir, generation))] = true
+			continue
+		}
+		os.Remove(manifestPath(dir, generation))
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if _, err := strconv.Atoi(name); (err == nil && info.IsDir() && !kept[name]) ||
+			strings.HasSuffix(name, tempSuffix) {
+			os.RemoveAll(filepath.Join(dir, name))
+		}
+	}
+}
+
+// commitWriter writes a new commit of an index
+type commitWriter struct {
+	dir      string
+	manifest manifest
+	// files being written
+	open []*checksumWriter
+}
+
+// newCommitWriter starts writing the commit following the latest one of the
+// index in dir
+func newCommitWriter(dir string) (*commitWriter, error) {
+	generations, err := commitGenerations(dir)
+	if err != nil {
+		return nil, err
+	}
+	generation := 1
+	if len(generations) > 0 {
+		generation = generations[0] + 1
+	}
+	c := &commitWriter{dir: dir, manifest: manifest{Generation: generation,
+		BlockSize: checksumBlockSize}}
+	path := generationDir(dir, generation)
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// create starts writing the named file of the commit
+func (c *commitWriter) create(name string) (*checksumWriter, error) {
+	path := filepath.Join(generationDir(c.dir, c.manifest.Generation), name)
+	file, err := os.Create(path + tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	w := &checksumWriter{c: c, path: path, file: file, w: bufio.NewWriter(file),
+		sum: fileChecksum{Name: name, Blocks: []uint32{}}}
+	c.open = append(c.open, w)
+	return w, nil
+}
+
+// finish commits the files written: the manifest listing them is written
+// under a temporary name, synced and renamed into place
+func (c *commitWriter) finish() error {
+	if len(c.open) > 0 {
+		return fmt.Errorf("%s: not closed before committing", c.open[0].sum.Name)
+	}
+	if err := syncDir(generationDir(c.dir, c.manifest.Generation)); err != nil {
+		return err
+	}
+	sort.Sort(byName(c.manifest.Files))
+	c.manifest.Checksum = c.manifest.sum()
+	contents, err := json.MarshalIndent(c.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := manifestPath(c.dir, c.manifest.Generation)
+	if err := writeFileSynced(path+tempSuffix, contents); err != nil {
+		return err
+	}
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		return err
+	}
+	return syncDir(c.dir)
+}
+
+// abort gives up on the commit, removing its files
+func (c *commitWriter) abort() {
+	for _, w := range c.open {
+		w.file.Close()
+	}
+	c.open = nil
+	os.RemoveAll(generationDir(c.dir, c.manifest.Generation))
+	os.Remove(manifestPath(c.dir, c.manifest.Generation) + tempSuffix)
+}
+
+// checksumWriter writes a file of a commit, computing its checksums
+type checksumWriter struct {
+	c    *commitWriter
+	path string
+	file *os.File
+	w    *bufio.Writer
+	sum  fileChecksum
+	// CRC32 and length of the current block so far
+	block   uint32
+	inBlock int
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.checksum(p[:n])
+	return n, err
+}
+
+func (w *checksumWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *checksumWriter) checksum(p []byte) {
+	w.sum.Size += int64(len(p))
+	w.sum.CRC32 = crc32.Update(w.sum.CRC32, crc32.IEEETable, p)
+	for len(p) > 0 {
+		n := w.c.manifest.BlockSize - w.inBlock
+		if n > len(p) {
+			n = len(p)
+		}
+		w.block = crc32.Update(w.block, crc32.IEEETable, p[:n])
+		w.inBlock += n
+		p = p[n:]
+		if w.inBlock == w.c.manifest.BlockSize {
+			w.sum.Blocks = append(w.sum.Blocks, w.block)
+			w.block, w.inBlock = 0, 0
+		}
+	}
+}
+
+// close flushes and syncs the file, renames it to its name and adds it to
+// the manifest
+func (w *checksumWriter) close() error {
+	if w.inBlock > 0 {
+		w.sum.Blocks = append(w.sum.Blocks, w.block)
+		w.block, w.inBlock = 0, 0
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.path+tempSuffix, w.path); err != nil {
+		return err
+	}
+	c := w.c
+	for k, open := range c.open {
+		if open == w {
+			c.open = append(c.open[:k], c.open[k+1:]...)
+			break
+		}
+	}
+	c.manifest.Files = append(c.manifest.Files, w.sum)
+	return nil
+}
+
+type byName []fileChecksum
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(a, b int)      { s[a], s[b] = s[b], s[a] }
+func (s byName) Less(a, b int) bool { return s[a].Name < s[b].Name }
+
+// writeFileSynced writes contents to a new file at path and syncs it
+func writeFileSynced(path string, contents []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir syncs the directory at path, so that the files renamed in it stay
+// renamed after a crash. Not every platform can sync a directory, so only
+// failing to open it is an error.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	dir.Sync()
+	return dir.Close()
+}
@@ -0,0 +1,235 @@
+package invertedindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for writing the commits of an index on disk and rolling back to
+// the last good one
+
+func TestCommitGenerations(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	for k := 0; k < 3; k++ {
+		setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+	}
+	// only the latest commits are kept
+	assertCommitGenerations(t, dir, []int{3, 2})
+	if _, err := os.Stat(generationDir(dir, 1)); !os.IsNotExist(err) {
+		t.Errorf("Expected the files of commit 1 to be removed, got: %v", err)
+	}
+}
+
+func TestRollBackCorruptCommit(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+	setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	assertOpenedDocuments(t, dir, 3)
+
+	// a torn write of the latest commit
+	path := filepath.Join(generationDir(dir, 2), postingsFile)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, contents[:len(contents)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	assertOpenedDocuments(t, dir, 1)
+
+	// a manifest that does not match its checksum
+	manifest := manifestPath(dir, 1)
+	contents, err = ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents = bytes.Replace(contents, []byte(`"size": `), []byte(`"size": 1`), 1)
+	if err := ioutil.WriteFile(manifest, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenIndex(dir); err == nil {
+		t.Error("Expected an error opening an index without a good commit")
+	}
+}
+
+func TestSearchCorruptPostings(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+	setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+
+	// flipped bits in every posting of the latest commit, checksummed in
+	// blocks small enough that only the magic is checked opening it lazily
+	rechecksum(t, dir, 2, len(postingsMagic))
+	path := filepath.Join(generationDir(dir, 2), postingsFile)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := len(postingsMagic); k < len(contents); k++ {
+		contents[k] ^= 1
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	if report := opened.Report(); report.Indexed != 1 {
+		t.Errorf("Expected the previous commit of 1 document to be opened, actual: %d", report.Indexed)
+	}
+	if _, err := opened.Search("ext:rs"); err != nil {
+		t.Errorf("Expected the previous commit to be searched, got: %v", err)
+	}
+
+	// the files going bad after the index was opened
+	lazy := new(Indexer)
+	if err := lazy.openIndex(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	defer lazy.Close()
+	if _, err := lazy.Search("ext:rs"); err == nil {
+		t.Error("Expected an error searching corrupt postings")
+	}
+	if _, err := lazy.RankedSearch("name:readme"); err == nil {
+		t.Error("Expected an error ranking once the index was found corrupt")
+	}
+}
+
+func TestRollBackUnfinishedCommit(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+
+	// a crash before the manifest was renamed into place
+	c, err := newCommitWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.create(termsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString(termsMagic); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.create(postingsFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(manifestPath(dir, 2)+tempSuffix, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assertCommitGenerations(t, dir, []int{1})
+	assertOpenedDocuments(t, dir, 1)
+
+	// the next build clears up what the crash left behind
+	setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+	assertCommitGenerations(t, dir, []int{2, 1})
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	expected := []string{"000001", "000002", "commit-000001.json", "commit-000002.json"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the index directory to hold: %v, actual: %v", expected, names)
+	}
+}
+
+func TestCheckedFile(t *testing.T) {
+	f := &checkedFile{data: []byte("abcdefghij"), blockSize: 4,
+		blocks:   []uint32{crc32.ChecksumIEEE([]byte("abcd")), 0, crc32.ChecksumIEEE([]byte("ij"))},
+		verified: make([]uint32, 3)}
+	if data, err := f.slice(1, 3); err != nil || string(data) != "bc" {
+		t.Errorf("Expected bc, actual: %q %v", data, err)
+	}
+	if data, err := f.slice(8, 10); err != nil || string(data) != "ij" {
+		t.Errorf("Expected ij, actual: %q %v", data, err)
+	}
+	// the middle block does not match its checksum
+	for _, r := range [][2]uint64{{3, 5}, {4, 8}, {9, 11}, {5, 4}} {
+		if _, err := f.slice(r[0], r[1]); err != errCorrupt {
+			t.Errorf("Expected bytes %v to be corrupt, got: %v", r, err)
+		}
+	}
+	if !reflect.DeepEqual(f.verified, []uint32{1, 0, 1}) {
+		t.Errorf("Unexpected blocks verified: %v", f.verified)
+	}
+}
+
+func TestChecksumBlocks(t *testing.T) {
+	c := &commitWriter{manifest: manifest{BlockSize: 4}}
+	w := &checksumWriter{c: c}
+	w.checksum([]byte("abcdef"))
+	w.checksum([]byte("ghij"))
+	expected := []uint32{crc32.ChecksumIEEE([]byte("abcd")), crc32.ChecksumIEEE([]byte("efgh"))}
+	if !reflect.DeepEqual(w.sum.Blocks, expected) || w.inBlock != 2 || w.sum.Size != 10 ||
+		w.sum.CRC32 != crc32.ChecksumIEEE([]byte("abcdefghij")) {
+		t.Errorf("Unexpected checksums: %+v", w.sum)
+	}
+}
+
+func assertCommitGenerations(t *testing.T, dir string, expected []int) {
+	generations, err := commitGenerations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(generations, expected) {
+		t.Errorf("Expected commits: %v, actual: %v", expected, generations)
+	}
+}
+
+// rechecksum rewrites the manifest of a commit for blocks of the passed size
+func rechecksum(t *testing.T, dir string, generation, blockSize int) {
+	m, err := readManifest(dir, generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.BlockSize = blockSize
+	for k, f := range m.Files {
+		contents, err := ioutil.ReadFile(filepath.Join(generationDir(dir, generation), f.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Files[k].Blocks = []uint32{}
+		for start := 0; start < len(contents); start += blockSize {
+			end := start + blockSize
+			if end > len(contents) {
+				end = len(contents)
+			}
+			m.Files[k].Blocks = append(m.Files[k].Blocks, crc32.ChecksumIEEE(contents[start:end]))
+		}
+	}
+	m.Checksum = m.sum()
+	contents, _ := json.Marshal(m)
+	if err := ioutil.WriteFile(manifestPath(dir, generation), contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertOpenedDocuments(t *testing.T, dir string, expected int) {
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	if report := opened.Report(); report.Indexed != expected {
+		t.Errorf("Expected %d documents, actual: %d", expected, report.Indexed)
+	}
+}
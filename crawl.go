@@ -0,0 +1,158 @@
+package invertedindex
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// binarySniffLength is how many leading bytes of a file are looked at to
+// decide whether it is binary
+const binarySniffLength = 8000
+
+// Reasons a file is skipped for besides an error reading it
+const (
+	reasonBinary    = "binary file"
+	reasonSize      = "larger than %d bytes"
+	reasonCycle     = "symlink cycle"
+	reasonSymlink   = "symlink to a directory"
+	reasonDuplicate = "same file as %s"
+)
+
+// SkippedFile is a file the crawl did not index, and why
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// CrawlReport records what happened to the files met while building an index
+type CrawlReport struct {
+	// number of documents indexed
+	Indexed int
+	// files that were not indexed, in the order they were met. Files left out
+	// by the Include, Exclude and RespectIgnore flags are not listed.
+	Skipped []SkippedFile
+}
+
+// Report returns the report of the crawl that built the index
+func (i *Indexer) Report() CrawlReport {
+	report := i.report
+	report.Indexed = i.documentCount()
+	return report
+}
+
+// skipFile records that the file at path was not indexed for the passed
+// reason
+func (i *Indexer) skipFile(path, reason string) {
+	if i.flags.Verbose {
+		fmt.Printf("Skipping file: %s (%s)\n", path, reason)
+	}
+	i.report.Skipped = append(i.report.Skipped, SkippedFile{Path: path, Reason: reason})
+}
+
+// tooLarge reports whether a file of the passed size exceeds the MaxFileSize
+// flag, recording it as skipped if so
+func (i *Indexer) tooLarge(path string, size int64) bool {
+	if i.flags.MaxFileSize > 0 && size > i.flags.MaxFileSize {
+		i.skipFile(path, fmt.Sprintf(reasonSize, i.flags.MaxFileSize))
+		return true
+	}
+	return false
+}
+
+// firstVisit reports whether a crawl following symlinks meets the file or
+// directory at path for the first time, recording it as skipped if not.
+// Directories are skipped when they are one of the directories being read,
+// as reached through a symlink cycle, and anything else is skipped when it
+// was already reached through another path.
+func (i *Indexer) firstVisit(info os.FileInfo, path string) bool {
+	if !i.flags.FollowSymlinks {
+		return true
+	}
+	key, ok := fileKeyOf(info, path)
+	if !ok {
+		return true
+	}
+	if i.crawling[key] {
+		i.skipFile(path, reasonCycle)
+		return false
+	}
+	if first, seen := i.visited[key]; seen {
+		i.skipFile(path, fmt.Sprintf(reasonDuplicate, first))
+		return false
+	}
+	i.visited[key] = path
+	return true
+}
+
+// pendingSymlink is a symlink a crawl following symlinks has yet to follow,
+// with the ignore rules and directories being read where it was met
+type pendingSymlink struct {
+	path     string
+	ignores  []ignoreRules
+	crawling map[fileKey]bool
+}
+
+// deferSymlink leaves the symlink at path to be followed once every real
+// file and directory has been read, so that those are indexed by their own
+// paths rather than by that of a link to them
+func (i *Indexer) deferSymlink(path string) {
+	crawling := make(map[fileKey]bool, len(i.crawling))
+	for key := range i.crawling {
+		crawling[key] = true
+	}
+	i.symlinks = append(i.symlinks, pendingSymlink{path,
+		append([]ignoreRules(nil), i.ignores...), crawling})
+}
+
+// followSymlinks follows the symlinks deferred by the crawl, and any met
+// while doing so, in the order they were met
+func (i *Indexer) followSymlinks() {
+	for len(i.symlinks) > 0 {
+		link := i.symlinks[0]
+		i.symlinks = i.symlinks[1:]
+		i.ignores, i.crawling = link.ignores, link.crawling
+		info, err := os.Stat(link.path)
+		if err != nil {
+			if i.flags.Abort {
+				fmt.Println(err)
+				i.cleanup()
+				os.Exit(1)
+			}
+			i.skipFile(link.path, err.Error())
+			continue
+		}
+		if i.skipPath(link.path, info.IsDir()) {
+			continue
+		}
+		if info.IsDir() {
+			if i.flags.Recursive {
+				i.readDirectory(info, link.path)
+			}
+		} else if i.firstVisit(info, link.path) {
+			i.readFile(info, filepath.Dir(link.path))
+		}
+	}
+	i.ignores, i.crawling = nil, make(map[fileKey]bool)
+}
+
+// isBinary sniffs the start of contents to tell whether they are binary
+// rather than text. Like git, it takes a NUL byte as a sure sign; failing
+// that, contents are binary if more than one in ten bytes is a control
+// character other than the usual whitespace.
+func isBinary(contents []byte) bool {
+	if len(contents) > binarySniffLength {
+		contents = contents[:binarySniffLength]
+	}
+	if bytes.IndexByte(contents, 0) >= 0 {
+		return true
+	}
+	control := 0
+	for _, b := range contents {
+		if (b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b) || b == 0x7f {
+			control++
+		}
+	}
+	return control*10 > len(contents)
+}
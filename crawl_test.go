@@ -0,0 +1,186 @@
+package invertedindex
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for the files a crawl skips and the report of them
+
+var binarypath string = "test_files/binary_files"
+
+func TestIsBinary(t *testing.T) {
+	assertBinary(t, []byte("plain text\twith tabs\r\nand lines\n"), false)
+	assertBinary(t, []byte{}, false)
+	assertBinary(t, []byte("text with a \x00 NUL"), true)
+	assertBinary(t, []byte("\x01\x02\x03\x04 mostly control"), true)
+	assertBinary(t, []byte("\x1b[1mbold\x1b[0m terminal output"), false)
+	assertBinary(t, []byte("caf\xc3\xa9 na\xc3\xafve"), false)
+}
+
+func TestCrawlSkipsBinaryFiles(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, binarypath)
+	expected := map[int]string{
+		0: filepath.Join(binarypath, "large.txt"),
+		1: filepath.Join(binarypath, "small.txt"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+	assertCrawlReport(t, indexer.Report(), CrawlReport{Indexed: 2, Skipped: []SkippedFile{
+		{Path: filepath.Join(binarypath, "pixel.png"), Reason: "binary file"},
+	}})
+}
+
+func TestCrawlMaxFileSize(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{MaxFileSize: 100}, binarypath)
+	expected := map[int]string{0: filepath.Join(binarypath, "small.txt")}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+	assertCrawlReport(t, indexer.Report(), CrawlReport{Indexed: 1, Skipped: []SkippedFile{
+		{Path: filepath.Join(binarypath, "large.txt"), Reason: "larger than 100 bytes"},
+		{Path: filepath.Join(binarypath, "pixel.png"), Reason: "binary file"},
+	}})
+}
+
+func TestCrawlMaxFileSizeOfArchiveMembers(t *testing.T) {
+	archive := filepath.Join(binarypath, "archive", "members.tar.gz")
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true, MaxFileSize: 200}, archive)
+	expected := map[int]string{0: archive + "!/small.txt"}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+	assertCrawlReport(t, indexer.Report(), CrawlReport{Indexed: 1, Skipped: []SkippedFile{
+		{Path: archive + "!/large.txt", Reason: "larger than 200 bytes"},
+	}})
+}
+
+func TestCrawlArchiveMemberUnderstatingItsSize(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	contents := bytes.Repeat([]byte("bomb "), 200)
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(contents)
+	fw.Close()
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	w, err := z.CreateRaw(&zip.FileHeader{Name: "bomb.txt", Method: zip.Deflate,
+		CRC32: crc32.ChecksumIEEE(contents), CompressedSize64: uint64(compressed.Len()),
+		UncompressedSize64: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	z.Close()
+	archive := filepath.Join(dir, "bomb.zip")
+	if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the member is read no further than its stated size, past which the
+	// archive is found corrupt
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true, MaxFileSize: 200}, archive)
+	assertCrawlReport(t, indexer.Report(), CrawlReport{Skipped: []SkippedFile{
+		{Path: archive, Reason: zip.ErrFormat.Error()},
+	}})
+}
+
+func TestCrawlCorruptArchive(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	contents, err := ioutil.ReadFile(filepath.Join(archivepath, "bundle.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "truncated.zip")
+	if err := ioutil.WriteFile(archive, contents[:len(contents)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexer := setUpIndexer(t, IndexerFlags{Archives: true}, archive)
+	report := indexer.Report()
+	if report.Indexed != 0 || len(report.Skipped) != 1 || report.Skipped[0].Path != archive {
+		t.Errorf("Expected the corrupt archive to be reported as skipped, actual: %+v", report)
+	}
+}
+
+var symlinkpath string = "test_files/symlink_files"
+
+func TestCrawlSkipsSymlinks(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true}, symlinkpath)
+	// a link to a file is read like the file, but linked directories are not
+	// descended into
+	expected := map[int]string{
+		0: filepath.Join(symlinkpath, "alias.txt"),
+		1: filepath.Join(symlinkpath, "shared/doc.txt"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+
+	report := indexer.Report()
+	reasons := map[string]string{}
+	for _, skipped := range report.Skipped {
+		reasons[skipped.Path] = skipped.Reason
+	}
+	for _, link := range []string{"a/shared", "b/self", "b/shared"} {
+		assertSkipReason(t, reasons, filepath.Join(symlinkpath, link), "symlink to a directory")
+	}
+	if _, ok := reasons[filepath.Join(symlinkpath, "broken")]; !ok {
+		t.Error("Expected the broken symlink to be reported")
+	}
+	if report.Indexed != 2 || len(report.Skipped) != 4 {
+		t.Errorf("Expected 2 files indexed and 4 skipped, actual: %+v", report)
+	}
+}
+
+func TestCrawlFollowingSymlinks(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true, FollowSymlinks: true}, symlinkpath)
+	// files and directories are reached by their own paths before any link
+	// to them
+	first := filepath.Join(symlinkpath, "shared")
+	expected := map[int]string{0: filepath.Join(first, "doc.txt")}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+	assertSearchResult(t, indexer, "shared document", []int{0})
+
+	report := indexer.Report()
+	reasons := map[string]string{}
+	for _, skipped := range report.Skipped {
+		reasons[skipped.Path] = skipped.Reason
+	}
+	assertSkipReason(t, reasons, filepath.Join(symlinkpath, "alias.txt"),
+		"same file as "+filepath.Join(first, "doc.txt"))
+	assertSkipReason(t, reasons, filepath.Join(symlinkpath, "b/self"), "symlink cycle")
+	assertSkipReason(t, reasons, filepath.Join(symlinkpath, "a/shared"), "same file as "+first)
+	assertSkipReason(t, reasons, filepath.Join(symlinkpath, "b/shared"), "same file as "+first)
+	if _, ok := reasons[filepath.Join(symlinkpath, "broken")]; !ok {
+		t.Error("Expected the broken symlink to be reported")
+	}
+	if len(report.Skipped) != 5 {
+		t.Errorf("Expected number of files skipped: 5, actual: %d", len(report.Skipped))
+	}
+}
+
+func TestCrawlFollowingSymlinkedRoot(t *testing.T) {
+	root := filepath.Join(symlinkpath, "b", "self")
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true, FollowSymlinks: true}, root)
+	expected := map[int]string{0: filepath.Join(root, "shared/doc.txt")}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func assertSkipReason(t *testing.T, reasons map[string]string, path, expected string) {
+	if reasons[path] != expected {
+		t.Errorf("Expected %s skipped because: %q, actual: %q", path, expected, reasons[path])
+	}
+}
+
+func assertBinary(t *testing.T, contents []byte, expected bool) {
+	if isBinary(contents) != expected {
+		t.Errorf("Expected %q binary to be %v", contents, expected)
+	}
+}
+
+func assertCrawlReport(t *testing.T, actual, expected CrawlReport) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected crawl report: %+v, actual: %+v", expected, actual)
+	}
+}
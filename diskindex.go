@@ -0,0 +1,327 @@
+package invertedindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// diskIndex is a commit of an index written to disk, read in place through
+// memory maps. It never changes once opened, so it is safe for concurrent
+// use.
+type diskIndex struct {
+	generation                                 int
+	meta                                       indexMeta
+	terms, termIndex, postings, docs, docIndex *checkedFile
+	unmap                                      []func() error
+	// the first corruption met reading the files, which fails every search
+	// of the index from then on
+	mu  sync.Mutex
+	err error
+}
+
+// checkedFile is a file of a commit whose blocks are checked against their
+// checksums when first read
+type checkedFile struct {
+	data      []byte
+	blockSize int
+	blocks    []uint32
+	// 1 for each block found to match its checksum
+	verified []uint32
+}
+
+// slice returns the bytes of the file in [start, end), or errCorrupt if any
+// block they span does not match its checksum
+func (f *checkedFile) slice(start, end uint64) ([]byte, error) {
+	if start > end || end > uint64(len(f.data)) {
+		return nil, errCorrupt
+	}
+	if start == end {
+		return f.data[start:end], nil
+	}
+	size := uint64(f.blockSize)
+	for b := start / size; b <= (end-1)/size; b++ {
+		if atomic.LoadUint32(&f.verified[b]) == 1 {
+			continue
+		}
+		blockEnd := (b + 1) * size
+		if blockEnd > uint64(len(f.data)) {
+			blockEnd = uint64(len(f.data))
+		}
+		if crc32.ChecksumIEEE(f.data[b*size:blockEnd]) != f.blocks[b] {
+			return nil, errCorrupt
+		}
+		atomic.StoreUint32(&f.verified[b], 1)
+	}
+	return f.data[start:end], nil
+}
+
+// openDiskIndex opens the latest commit of the index written to dir whose
+// files are whole, rolling back to earlier commits if the latest are not.
+// With verify set every block of a commit is checked before it is used, and
+// a commit with a corrupt block is rolled back too.
+func openDiskIndex(dir string, verify bool) (*diskIndex, error) {
+	generations, err := commitGenerations(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(generations) == 0 {
+		return nil, fmt.Errorf("%s: no commit of an index", dir)
+	}
+	var first error
+	for _, generation := range generations {
+		d, err := openCommit(dir, generation)
+		if err == nil && verify {
+			if err = d.verify(); err != nil {
+				d.close()
+			}
+		}
+		if err == nil {
+			return d, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return nil, first
+}
+
+// openCommit opens the commit of the passed generation. Its manifest, the
+// sizes of its files and the meta file are checked up front, and the blocks
+// of the other files as they are read.
+func openCommit(dir string, generation int) (*diskIndex, error) {
+	m, err := readManifest(dir, generation)
+	if err != nil {
+		return nil, fmt.Errorf("commit %d: %s", generation, err)
+	}
+	path := generationDir(dir, generation)
+	contents, err := ioutil.ReadFile(filepath.Join(path, metaFile))
+	if err != nil {
+		return nil, fmt.Errorf("commit %d: %s", generation, err)
+	}
+	if sum, ok := m.file(metaFile); !ok || crc32.ChecksumIEEE(contents) != sum.CRC32 {
+		return nil, fmt.Errorf("commit %d: %s: %s", generation, metaFile, errCorrupt)
+	}
+	d := &diskIndex{generation: generation}
+	if err := json.Unmarshal(contents, &d.meta); err != nil {
+		return nil, fmt.Errorf("commit %d: %s: %s", generation, metaFile, err)
+	}
+	files := []struct {
+		name, magic string
+		file        **checkedFile
+	}{
+		{termsFile, termsMagic, &d.terms},
+		{termIndexFile, termIndexMagic, &d.termIndex},
+		{postingsFile, postingsMagic, &d.postings},
+		{docsFile, "", &d.docs},
+		{docIndexFile, docIndexMagic, &d.docIndex},
+	}
+	for _, file := range files {
+		f, err := d.mapFile(path, m, file.name)
+		if err == nil {
+			magic, e := f.slice(0, uint64(len(file.magic)))
+			if err = e; err == nil && string(magic) != file.magic {
+				err = fmt.Errorf("not an index file of version %s", file.magic)
+			}
+		}
+		if err != nil {
+			d.close()
+			return nil, fmt.Errorf("commit %d: %s: %s", generation, file.name, err)
+		}
+		*file.file = f
+	}
+	if (len(d.termIndex.data)-len(termIndexMagic))%8 != 0 ||
+		len(d.docIndex.data) != len(docIndexMagic)+8*d.meta.NextDocID {
+		d.close()
+		return nil, fmt.Errorf("commit %d: %s", generation, errCorrupt)
+	}
+	return d, nil
+}
+
+// mapFile maps the named file of a commit into memory, checking it has the
+// size and number of blocks its manifest says
+func (d *diskIndex) mapFile(path string, m manifest, name string) (*checkedFile, error) {
+	sum, ok := m.file(name)
+	if !ok {
+		return nil, fmt.Errorf("missing from the manifest")
+	}
+	data, unmap, err := mmapFile(filepath.Join(path, name))
+	if err != nil {
+		return nil, err
+	}
+	d.unmap = append(d.unmap, unmap)
+	blocks := (len(data) + m.BlockSize - 1) / m.BlockSize
+	if int64(len(data)) != sum.Size || len(sum.Blocks) != blocks {
+		return nil, fmt.Errorf("%d bytes rather than %d", len(data), sum.Size)
+	}
+	return &checkedFile{data: data, blockSize: m.BlockSize, blocks: sum.Blocks,
+		verified: make([]uint32, blocks)}, nil
+}
+
+// verify checks every block of the files of the commit against its
+// checksum
+func (d *diskIndex) verify() error {
+	files := []struct {
+		name string
+		file *checkedFile
+	}{
+		{termsFile, d.terms},
+		{termIndexFile, d.termIndex},
+		{postingsFile, d.postings},
+		{docsFile, d.docs},
+		{docIndexFile, d.docIndex},
+	}
+	for _, file := range files {
+		if _, err := file.file.slice(0, uint64(len(file.file.data))); err != nil {
+			return fmt.Errorf("commit %d: %s: %s", d.generation, file.name, err)
+		}
+	}
+	return nil
+}
+
+// fail records that reading the index met err, if it is the first error
+// met
+func (d *diskIndex) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = fmt.Errorf("commit %d: %s", d.generation, err)
+	}
+}
+
+// failure returns the first error met reading the index, if any
+func (d *diskIndex) failure() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// close unmaps the files of the index
+func (d *diskIndex) close() error {
+	var err error
+	for _, unmap := range d.unmap {
+		if e := unmap(); err == nil {
+			err = e
+		}
+	}
+	d.unmap = nil
+	return err
+}
+
+// termCount returns the number of entries of the terms file
+func (d *diskIndex) termCount() int {
+	return (len(d.termIndex.data) - len(termIndexMagic)) / 8
+}
+
+// entry returns the term entry with the passed index
+func (d *diskIndex) entry(k int) (termEntry, error) {
+	start := uint64(len(termIndexMagic) + 8*k)
+	data, err := d.termIndex.slice(start, start+8)
+	if err != nil {
+		return termEntry{}, err
+	}
+	offset := binary.LittleEndian.Uint64(data)
+	if offset >= uint64(len(d.terms.data)) {
+		return termEntry{}, errCorrupt
+	}
+	e, n, err := parseTermEntry(d.terms.data[offset:])
+	if err != nil {
+		return e, err
+	}
+	if _, err := d.terms.slice(offset, offset+uint64(n)); err != nil {
+		return termEntry{}, err
+	}
+	return e, nil
+}
+
+// key returns the field and term of the entry with the passed index. Those
+// of a corrupt entry sort first, and fail the index.
+func (d *diskIndex) key(k int) (string, string) {
+	e, err := d.entry(k)
+	if err != nil {
+		d.fail(err)
+	}
+	return e.field, e.term
+}
+
+// fieldRange returns the range [start, end) of the term entries of the named
+// field
+func (d *diskIndex) fieldRange(name string) (int, int) {
+	n := d.termCount()
+	start := sort.Search(n, func(k int) bool {
+		field, _ := d.key(k)
+		return field >= name
+	})
+	end := start + sort.Search(n-start, func(k int) bool {
+		field, _ := d.key(start + k)
+		return field > name
+	})
+	return start, end
+}
+
+// find returns the entry of term among the entries in [start, end), and
+// whether there is one
+func (d *diskIndex) find(start, end int, term string) (termEntry, bool) {
+	k := start + sort.Search(end-start, func(k int) bool {
+		_, t := d.key(start + k)
+		return t >= term
+	})
+	if k == end {
+		return termEntry{}, false
+	}
+	e, err := d.entry(k)
+	if err != nil {
+		d.fail(err)
+		return termEntry{}, false
+	}
+	return e, e.term == term
+}
+
+// postingsOf decodes the postings of a term entry
+func (d *diskIndex) postingsOf(e termEntry) ([]int, map[int][]occurrence, error) {
+	data, err := d.postings.slice(e.offset, e.offset+e.length)
+	if err != nil || e.offset+e.length < e.offset {
+		return nil, nil, errCorrupt
+	}
+	return decodePostings(data)
+}
+
+// document reads the record of the document with the passed docID, and
+// tells whether there is such a document
+func (d *diskIndex) document(docID int) (docRecord, bool, error) {
+	var record docRecord
+	if docID < 0 || docID >= d.meta.NextDocID {
+		return record, false, nil
+	}
+	start := uint64(len(docIndexMagic) + 8*docID)
+	data, err := d.docIndex.slice(start, start+8)
+	if err != nil {
+		return record, false, err
+	}
+	offset := binary.LittleEndian.Uint64(data)
+	if offset == 0 {
+		return record, false, nil
+	}
+	if offset > uint64(len(d.docs.data)) {
+		return record, false, errCorrupt
+	}
+	end := uint64(len(d.docs.data))
+	if n := bytes.IndexByte(d.docs.data[offset-1:], '\n'); n >= 0 {
+		end = offset - 1 + uint64(n)
+	}
+	line, err := d.docs.slice(offset-1, end)
+	if err != nil {
+		return record, false, err
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return record, false, err
+	}
+	return record, true, nil
+}
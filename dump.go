@@ -0,0 +1,103 @@
+package invertedindex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TermPosting is the posting of a term in one document, as dumped for
+// debugging
+type TermPosting struct {
+	DocID int `json:"docID"`
+	// number of occurrences of the term in the document
+	Frequency int `json:"frequency"`
+	// token positions of the occurrences; empty for fields indexed without
+	// positions
+	Positions []int `json:"positions,omitempty"`
+}
+
+// DocumentTerm is a term a document contributed to a field, and how many
+// times
+type DocumentTerm struct {
+	Field     string `json:"field"`
+	Term      string `json:"term"`
+	Frequency int    `json:"frequency"`
+}
+
+// Postings returns the postings of term in the named field, in docID order
+func (i *Indexer) Postings(fieldName, term string) ([]TermPosting, error) {
+	f, err := i.field(fieldName)
+	if err != nil {
+		return nil, err
+	}
+	term = f.normalize(term)
+	postings := []TermPosting{}
+	docIDs, positions := f.lookup(term)
+	for _, docID := range docIDs {
+		posting := TermPosting{DocID: docID, Frequency: 1}
+		if occurrences, ok := positions[docID]; ok {
+			posting.Frequency = len(occurrences)
+			for _, o := range occurrences {
+				posting.Positions = append(posting.Positions, o.position)
+			}
+		}
+		postings = append(postings, posting)
+	}
+	return postings, nil
+}
+
+// TermsWithPrefix returns the terms of the named field starting with prefix
+// in sorted order, along with their frequencies
+func (i *Indexer) TermsWithPrefix(fieldName, prefix string) ([]TermStats, error) {
+	f, err := i.field(fieldName)
+	if err != nil {
+		return nil, err
+	}
+	prefix = f.normalize(prefix)
+	dictionary := f.dictionary()
+	terms := []TermStats{}
+	for k := sort.SearchStrings(dictionary, prefix); k < len(dictionary); k++ {
+		if !strings.HasPrefix(dictionary[k], prefix) {
+			break
+		}
+		terms = append(terms, f.termStats(dictionary[k]))
+	}
+	return terms, nil
+}
+
+// DocumentTerms returns every term the document with the passed docID
+// contributed to the index, ordered by field and then term
+func (i *Indexer) DocumentTerms(docID int) ([]DocumentTerm, error) {
+	if _, ok := i.Path(docID); !ok {
+		return nil, fmt.Errorf("no document with docID %d", docID)
+	}
+	i.loadAll()
+	terms := []DocumentTerm{}
+	for name, f := range i.fields {
+		for term, docIDs := range f.postings {
+			k := sort.SearchInts(docIDs, docID)
+			if k == len(docIDs) || docIDs[k] != docID {
+				continue
+			}
+			frequency := 1
+			if occurrences, ok := f.positions[term][docID]; ok {
+				frequency = len(occurrences)
+			}
+			terms = append(terms, DocumentTerm{Field: name, Term: term, Frequency: frequency})
+		}
+	}
+	sort.Sort(byFieldAndTerm(terms))
+	return terms, nil
+}
+
+type byFieldAndTerm []DocumentTerm
+
+func (s byFieldAndTerm) Len() int      { return len(s) }
+func (s byFieldAndTerm) Swap(a, b int) { s[a], s[b] = s[b], s[a] }
+func (s byFieldAndTerm) Less(a, b int) bool {
+	if s[a].Field != s[b].Field {
+		return s[a].Field < s[b].Field
+	}
+	return s[a].Term < s[b].Term
+}
@@ -0,0 +1,91 @@
+package invertedindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tests for dumping the contents of an index
+
+func TestPostings(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, snippetpath)
+	postings, err := indexer.Postings(bodyField, "quick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TermPosting{{DocID: 0, Frequency: 3, Positions: []int{1, 19, 31}}}
+	if !reflect.DeepEqual(postings, expected) {
+		t.Errorf("Expected postings: %v, actual: %v", expected, postings)
+	}
+	if postings, _ := indexer.Postings(bodyField, "absent"); len(postings) != 0 {
+		t.Errorf("Expected no postings, actual: %v", postings)
+	}
+}
+
+func TestPostingsWithoutPositions(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath)
+	postings, err := indexer.Postings(extField, "TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TermPosting{{DocID: 1, Frequency: 1}}
+	if !reflect.DeepEqual(postings, expected) {
+		t.Errorf("Expected postings: %v, actual: %v", expected, postings)
+	}
+}
+
+func TestPostingsOfUnknownField(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, snippetpath)
+	if _, err := indexer.Postings("nofield", "quick"); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
+func TestTermsWithPrefix(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, snippetpath)
+	terms, err := indexer.TermsWithPrefix(bodyField, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TermStats{
+		{Term: "box", DocumentFrequency: 1, CollectionFrequency: 1},
+		{Term: "boxing", DocumentFrequency: 1, CollectionFrequency: 1},
+		{Term: "brown", DocumentFrequency: 1, CollectionFrequency: 2},
+	}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Expected terms: %v, actual: %v", expected, terms)
+	}
+	if terms, _ := indexer.TermsWithPrefix(bodyField, "zz"); len(terms) != 0 {
+		t.Errorf("Expected no terms, actual: %v", terms)
+	}
+}
+
+func TestDocumentTerms(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath)
+	terms, err := indexer.DocumentTerms(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DocumentTerm{
+		{Field: bodyField, Term: "for", Frequency: 1},
+		{Field: bodyField, Term: "install", Frequency: 1},
+		{Field: bodyField, Term: "notes", Frequency: 1},
+		{Field: bodyField, Term: "the", Frequency: 1},
+		{Field: bodyField, Term: "tool", Frequency: 1},
+		{Field: extField, Term: "txt", Frequency: 1},
+		{Field: nameField, Term: "my", Frequency: 1},
+		{Field: nameField, Term: "my_notes", Frequency: 1},
+		{Field: nameField, Term: "my_notes.txt", Frequency: 1},
+		{Field: nameField, Term: "notes", Frequency: 1},
+		{Field: pathField, Term: "field_files", Frequency: 1},
+		{Field: pathField, Term: "my_notes.txt", Frequency: 1},
+		{Field: pathField, Term: "notes", Frequency: 1},
+		{Field: pathField, Term: "test_files", Frequency: 1},
+	}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Expected terms: %v, actual: %v", expected, terms)
+	}
+	if _, err := indexer.DocumentTerms(10); err == nil {
+		t.Error("Expected an error for a missing document")
+	}
+}
@@ -0,0 +1,154 @@
+package invertedindex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxLatin1HighBytes is the largest share of bytes outside ASCII, in
+// percent, that text may have to be taken for Latin-1. Latin-1 text in any
+// language is mostly ASCII; past this it is more likely some other encoding.
+const maxLatin1HighBytes = 30
+
+// byte order marks
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+var errBinary = errors.New(reasonBinary)
+
+// windows1252 maps the bytes 0x80 to 0x9f to the characters Windows-1252
+// gives them, which is what files labelled Latin-1 use in practice. The
+// bytes it leaves undefined map to 0.
+var windows1252 = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+// decodeText returns the contents of a text file transcoded to UTF-8. The
+// encoding is told by the byte order mark if there is one, and otherwise
+// guessed: UTF-16 without a BOM by the NUL bytes of its ASCII characters,
+// then UTF-8 if the contents are valid UTF-8, then Latin-1. An error tells
+// why the contents could not be decoded, which includes their being binary.
+func decodeText(contents []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(contents, bomUTF8):
+		contents = contents[len(bomUTF8):]
+		if !utf8.Valid(contents) {
+			return nil, fmt.Errorf("invalid UTF-8")
+		}
+		return contents, nil
+	case bytes.HasPrefix(contents, bomUTF16LE):
+		return decodeUTF16(contents[len(bomUTF16LE):], false)
+	case bytes.HasPrefix(contents, bomUTF16BE):
+		return decodeUTF16(contents[len(bomUTF16BE):], true)
+	}
+	if isBinary(contents) {
+		if bigEndian, ok := sniffUTF16(contents); ok {
+			return decodeUTF16(contents, bigEndian)
+		}
+		return nil, errBinary
+	}
+	if utf8.Valid(contents) {
+		return contents, nil
+	}
+	return decodeLatin1(contents)
+}
+
+// sniffUTF16 guesses whether contents are UTF-16 without a byte order mark,
+// and if so its byte order, by looking for the NUL bytes that every ASCII
+// character has in UTF-16. It only recognizes text that is mostly ASCII.
+func sniffUTF16(contents []byte) (bigEndian bool, ok bool) {
+	if len(contents) > binarySniffLength {
+		contents = contents[:binarySniffLength]
+	}
+	if len(contents) < 2 || len(contents)%2 != 0 {
+		return false, false
+	}
+	var nuls [2]int
+	for k, b := range contents {
+		if b == 0 {
+			nuls[k%2]++
+		}
+	}
+	units := len(contents) / 2
+	switch {
+	case nuls[0]*10 >= units*9 && nuls[1] == 0:
+		return true, true
+	case nuls[1]*10 >= units*9 && nuls[0] == 0:
+		return false, true
+	}
+	return false, false
+}
+
+// decodeUTF16 transcodes UTF-16 to UTF-8. Unpaired surrogates and a
+// trailing odd byte are errors.
+func decodeUTF16(contents []byte, bigEndian bool) ([]byte, error) {
+	if len(contents)%2 != 0 {
+		return nil, fmt.Errorf("invalid UTF-16: odd number of bytes")
+	}
+	units := make([]uint16, len(contents)/2)
+	for k := range units {
+		if bigEndian {
+			units[k] = uint16(contents[2*k])<<8 | uint16(contents[2*k+1])
+		} else {
+			units[k] = uint16(contents[2*k+1])<<8 | uint16(contents[2*k])
+		}
+	}
+	var text bytes.Buffer
+	for k := 0; k < len(units); k++ {
+		r := rune(units[k])
+		if utf16.IsSurrogate(r) {
+			if k+1 == len(units) {
+				return nil, fmt.Errorf("invalid UTF-16: unpaired surrogate")
+			}
+			r = utf16.DecodeRune(r, rune(units[k+1]))
+			if r == utf8.RuneError {
+				return nil, fmt.Errorf("invalid UTF-16: unpaired surrogate")
+			}
+			k++
+		}
+		text.WriteRune(r)
+	}
+	return text.Bytes(), nil
+}
+
+// decodeLatin1 transcodes Latin-1, as extended by Windows-1252, to UTF-8.
+// Contents that are unlikely to be Latin-1 are errors: those using bytes
+// Windows-1252 leaves undefined, those with too many bytes outside ASCII,
+// and those containing valid UTF-8 sequences, which are broken UTF-8 much
+// more often than Latin-1 that happens to look like them.
+func decodeLatin1(contents []byte) ([]byte, error) {
+	high := 0
+	for k := 0; k < len(contents); {
+		r, size := utf8.DecodeRune(contents[k:])
+		if size > 1 {
+			return nil, fmt.Errorf("invalid UTF-8")
+		}
+		if r == utf8.RuneError {
+			high++
+		}
+		k += size
+	}
+	if high*100 > len(contents)*maxLatin1HighBytes {
+		return nil, fmt.Errorf("unknown encoding")
+	}
+	var text bytes.Buffer
+	for _, b := range contents {
+		r := rune(b)
+		if b >= 0x80 && b < 0xa0 {
+			if r = windows1252[b-0x80]; r == 0 {
+				return nil, fmt.Errorf("unknown encoding: byte %#x is not Latin-1", b)
+			}
+		}
+		text.WriteRune(r)
+	}
+	return text.Bytes(), nil
+}
@@ -0,0 +1,76 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Tests for detecting the encoding of files and transcoding them to UTF-8
+
+var encodingpath string = "test_files/encoding_files"
+
+func TestDecodeUTF8(t *testing.T) {
+	assertDecoded(t, []byte("caf\xc3\xa9"), "café")
+	assertDecoded(t, []byte("\xef\xbb\xbfcaf\xc3\xa9"), "café")
+	assertDecoded(t, []byte{}, "")
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	assertDecoded(t, []byte{0xff, 0xfe, 'h', 0, 'i', 0, 0xe9, 0}, "hié")
+	assertDecoded(t, []byte{0xfe, 0xff, 0, 'h', 0, 'i', 0, 0xe9}, "hié")
+	// a character outside the basic multilingual plane, as a surrogate pair
+	assertDecoded(t, []byte{0xff, 0xfe, 0x3d, 0xd8, 0x00, 0xde}, "\U0001f600")
+	assertDecoded(t, []byte{'h', 0, 'e', 0, 'y', 0, '\n', 0}, "hey\n")
+	assertDecoded(t, []byte{0, 'h', 0, 'e', 0, 'y', 0, '\n'}, "hey\n")
+}
+
+func TestDecodeLatin1(t *testing.T) {
+	assertDecoded(t, []byte("caf\xe9 na\xefve"), "café naïve")
+	assertDecoded(t, []byte("\x93quoted\x94 \x80"), "“quoted” €")
+}
+
+func TestDecodeErrors(t *testing.T) {
+	assertNotDecoded(t, []byte{0xff, 0xfe, 'h', 0, 'i'}, "invalid UTF-16: odd number of bytes")
+	assertNotDecoded(t, []byte{0xff, 0xfe, 0x3d, 0xd8}, "invalid UTF-16: unpaired surrogate")
+	assertNotDecoded(t, []byte{0xef, 0xbb, 0xbf, 0xe9}, "invalid UTF-8")
+	assertNotDecoded(t, []byte("caf\xc3\xa9 caf\xe9"), "invalid UTF-8")
+	assertNotDecoded(t, []byte("\xe9\xe8\xe0 abc"), "unknown encoding")
+	assertNotDecoded(t, []byte("undefined \x81 byte"), "unknown encoding: byte 0x81 is not Latin-1")
+	assertNotDecoded(t, []byte{0x89, 'P', 'N', 'G', 0, 0, 0, 0x0d, 1}, "binary file")
+}
+
+func TestCrawlTranscodesFiles(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, encodingpath)
+	assertSearchResult(t, indexer, "café naïve", []int{0, 1, 2, 3, 4, 5})
+	assertSearchResult(t, indexer, "“quoted”", []int{0})
+	assertCrawlReport(t, indexer.Report(), CrawlReport{Indexed: 6, Skipped: []SkippedFile{
+		{Path: filepath.Join(encodingpath, "broken.txt"), Reason: "invalid UTF-8"},
+	}})
+}
+
+func TestSnippetsOfTranscodedFile(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(encodingpath, "utf16le.txt"))
+	snippets, err := indexer.Snippets("naïve", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{"café au lait [naïve]\n"})
+}
+
+func assertDecoded(t *testing.T, contents []byte, expected string) {
+	text, err := decodeText(contents)
+	if err != nil {
+		t.Errorf("Expected %q to decode, got error: %s", contents, err)
+	} else if string(text) != expected {
+		t.Errorf("Expected %q to decode to %q, actual: %q", contents, expected, text)
+	}
+}
+
+func assertNotDecoded(t *testing.T, contents []byte, expected string) {
+	_, err := decodeText(contents)
+	if err == nil {
+		t.Errorf("Expected %q not to decode", contents)
+	} else if err.Error() != expected {
+		t.Errorf("Expected error decoding %q: %s, actual: %s", contents, expected, err)
+	}
+}
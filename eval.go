@@ -0,0 +1,269 @@
+package invertedindex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultEvalCutoff is the rank precision is measured at unless told
+// otherwise
+const DefaultEvalCutoff = 10
+
+// Topic is a query of an evaluation, identified as its relevance judgments
+// are
+type Topic struct {
+	ID    string `json:"id"`
+	Query string `json:"query"`
+}
+
+// Qrels are relevance judgments in the format of TREC: topic ID -> document
+// -> relevance, a document being relevant to the topic if its relevance is
+// positive. Documents are named by their external ID if they were read from
+// a JSON Lines file, and by their path as indexed otherwise.
+type Qrels map[string]map[string]int
+
+// ReadTopics reads the topics of an evaluation. Either every line holds a
+// topic ID followed by whitespace and the query, with empty lines and lines
+// starting with # ignored, or the topics are in the format of TREC, where
+// the query is the title:
+//
+//	<top>
+//	<num> Number: 401
+//	<title> foreign minorities, germany
+//	...
+//	</top>
+func ReadTopics(r io.Reader) ([]Topic, error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(contents), "<top>") {
+		return readTRECTopics(string(contents))
+	}
+	topics := []Topic{}
+	for n, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(strings.Join(strings.Fields(line), " "), " ", 2)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d of topics: no query for topic %s", n+1, fields[0])
+		}
+		topics = append(topics, Topic{ID: fields[0], Query: fields[1]})
+	}
+	return topics, nil
+}
+
+var (
+	trecTopic = regexp.MustCompile(`(?s)<top>(.*?)</top>`)
+	trecNum   = regexp.MustCompile(`(?s)<num>\s*(?:Number:)?\s*([^<\s]+)`)
+	trecTitle = regexp.MustCompile(`(?s)<title>\s*(?:Topic:)?([^<]*)`)
+)
+
+// readTRECTopics reads topics in the format of TREC
+func readTRECTopics(contents string) ([]Topic, error) {
+	topics := []Topic{}
+	for n, top := range trecTopic.FindAllStringSubmatch(contents, -1) {
+		num := trecNum.FindStringSubmatch(top[1])
+		title := trecTitle.FindStringSubmatch(top[1])
+		if num == nil || title == nil || strings.TrimSpace(title[1]) == "" {
+			return nil, fmt.Errorf("topic %d lacks a number or a title", n+1)
+		}
+		topics = append(topics, Topic{ID: num[1], Query: strings.Join(strings.Fields(title[1]), " ")})
+	}
+	return topics, nil
+}
+
+// ReadQrels reads relevance judgments in the format of TREC, a line of
+//
+//	topic iteration document relevance
+//
+// for every judgment. The iteration is ignored.
+func ReadQrels(r io.Reader) (Qrels, error) {
+	qrels := make(Qrels)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d of qrels: expected 4 fields, found %d", n, len(fields))
+		}
+		relevance, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d of qrels: invalid relevance %q", n, fields[3])
+		}
+		if qrels[fields[0]] == nil {
+			qrels[fields[0]] = make(map[string]int)
+		}
+		qrels[fields[0]][fields[2]] = relevance
+	}
+	return qrels, scanner.Err()
+}
+
+// Metrics measure how well a ranking of documents retrieves the relevant
+// ones. Averaged over queries, AveragePrecision is the MAP and
+// ReciprocalRank the MRR.
+type Metrics struct {
+	// fraction of the first K documents ranked that are relevant
+	PrecisionAtK float64 `json:"precisionAtK"`
+	// fraction of the relevant documents ranked at all
+	Recall float64 `json:"recall"`
+	// mean of the precision at the rank of each relevant document, counting
+	// those not ranked as 0
+	AveragePrecision float64 `json:"averagePrecision"`
+	// 1 / the rank of the first relevant document, or 0 if none is ranked
+	ReciprocalRank float64 `json:"reciprocalRank"`
+	// discounted cumulative gain of the ranking, the sum of relevance /
+	// log2(1 + rank), over that of the best ranking possible
+	NDCG float64 `json:"ndcg"`
+}
+
+// QueryEvaluation is the evaluation of the ranking of one topic's query
+type QueryEvaluation struct {
+	Topic string `json:"topic"`
+	Query string `json:"query"`
+	// numbers of documents ranked, judged relevant, and both
+	Retrieved         int `json:"retrieved"`
+	Relevant          int `json:"relevant"`
+	RelevantRetrieved int `json:"relevantRetrieved"`
+	Metrics
+	// set if the query could not be run, in which case it ranks nothing
+	Error string `json:"error,omitempty"`
+}
+
+// Evaluation is the evaluation of the rankings of a set of topics' queries
+type Evaluation struct {
+	K       int               `json:"k"`
+	Queries []QueryEvaluation `json:"queries"`
+	// metrics averaged over Queries
+	Mean Metrics `json:"mean"`
+	// topics left out for having no document judged relevant
+	Unjudged []string `json:"unjudged"`
+}
+
+// Evaluate runs the query of every topic as a ranked search, and measures
+// the rankings against the relevance judgments of qrels, precision being
+// measured over the first k documents ranked. Topics are evaluated in the
+// order passed.
+func (i *Indexer) Evaluate(topics []Topic, qrels Qrels, k int) Evaluation {
+	e := Evaluation{K: k, Queries: []QueryEvaluation{}, Unjudged: []string{}}
+	for _, topic := range topics {
+		judgments := qrels[topic.ID]
+		if relevantCount(judgments) == 0 {
+			e.Unjudged = append(e.Unjudged, topic.ID)
+			continue
+		}
+		ranking := []string{}
+		hits, err := i.RankedSearch(topic.Query)
+		for _, hit := range hits {
+			ranking = append(ranking, i.documentName(hit.DocID))
+		}
+		q := evaluateRanking(ranking, judgments, k)
+		q.Topic, q.Query = topic.ID, topic.Query
+		if err != nil {
+			q.Error = err.Error()
+		}
+		e.Queries = append(e.Queries, q)
+	}
+	if len(e.Queries) == 0 {
+		return e
+	}
+	for _, q := range e.Queries {
+		e.Mean.PrecisionAtK += q.PrecisionAtK
+		e.Mean.Recall += q.Recall
+		e.Mean.AveragePrecision += q.AveragePrecision
+		e.Mean.ReciprocalRank += q.ReciprocalRank
+		e.Mean.NDCG += q.NDCG
+	}
+	n := float64(len(e.Queries))
+	e.Mean = Metrics{e.Mean.PrecisionAtK / n, e.Mean.Recall / n, e.Mean.AveragePrecision / n,
+		e.Mean.ReciprocalRank / n, e.Mean.NDCG / n}
+	return e
+}
+
+// documentName returns the name relevance judgments know the document with
+// the passed docID by
+func (i *Indexer) documentName(docID int) string {
+	if id, ok := i.ExternalID(docID); ok {
+		return id
+	}
+	path, _ := i.Path(docID)
+	return path
+}
+
+// evaluateRanking measures a ranking of documents, named as in judgments,
+// against judgments; a document ranked twice only counts the first time
+func evaluateRanking(ranking []string, judgments map[string]int, k int) QueryEvaluation {
+	q := QueryEvaluation{Relevant: relevantCount(judgments)}
+	seen := make(map[string]bool)
+	dcg, relevantAtK := 0.0, 0
+	for _, name := range ranking {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		q.Retrieved++
+		relevance := judgments[name]
+		if relevance <= 0 {
+			continue
+		}
+		q.RelevantRetrieved++
+		if q.Retrieved <= k {
+			relevantAtK++
+		}
+		if q.ReciprocalRank == 0 {
+			q.ReciprocalRank = 1 / float64(q.Retrieved)
+		}
+		q.AveragePrecision += float64(q.RelevantRetrieved) / float64(q.Retrieved)
+		dcg += float64(relevance) / math.Log2(float64(q.Retrieved)+1)
+	}
+	if k > 0 {
+		q.PrecisionAtK = float64(relevantAtK) / float64(k)
+	}
+	if q.Relevant > 0 {
+		q.Recall = float64(q.RelevantRetrieved) / float64(q.Relevant)
+		q.AveragePrecision /= float64(q.Relevant)
+	}
+	if ideal := idealDCG(judgments); ideal > 0 {
+		q.NDCG = dcg / ideal
+	}
+	return q
+}
+
+// idealDCG returns the discounted cumulative gain of ranking the relevant
+// documents of judgments most relevant first
+func idealDCG(judgments map[string]int) float64 {
+	relevances := []int{}
+	for _, relevance := range judgments {
+		if relevance > 0 {
+			relevances = append(relevances, relevance)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(relevances)))
+	dcg := 0.0
+	for rank, relevance := range relevances {
+		dcg += float64(relevance) / math.Log2(float64(rank)+2)
+	}
+	return dcg
+}
+
+// relevantCount returns the number of documents judgments judge relevant
+func relevantCount(judgments map[string]int) int {
+	n := 0
+	for _, relevance := range judgments {
+		if relevance > 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,118 @@
+package invertedindex
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests for evaluating rankings against relevance judgments
+
+var evalpath string = "test_files/eval_files"
+
+func TestReadTopics(t *testing.T) {
+	expected := []Topic{{ID: "1", Query: "alpha"}, {ID: "2", Query: "gamma delta"}}
+	for _, name := range []string{"topics.txt", "topics.trec"} {
+		f, err := os.Open(filepath.Join(evalpath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		topics, err := ReadTopics(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(topics[:2], expected) {
+			t.Errorf("Expected the topics of %s to start with %v, actual: %v", name, expected, topics)
+		}
+	}
+	if _, err := ReadTopics(strings.NewReader("1 alpha\n2\n")); err == nil {
+		t.Error("Expected an error reading a topic without a query")
+	}
+	if _, err := ReadTopics(strings.NewReader("<top><num> 1 </top>")); err == nil {
+		t.Error("Expected an error reading a TREC topic without a title")
+	}
+}
+
+func TestReadQrels(t *testing.T) {
+	qrels, err := ReadQrels(strings.NewReader("1 0 a 1\n\n1 0 b 0\n2 Q0 a 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Qrels{"1": {"a": 1, "b": 0}, "2": {"a": 2}}
+	if !reflect.DeepEqual(qrels, expected) {
+		t.Errorf("Expected qrels: %v, actual: %v", expected, qrels)
+	}
+	for _, contents := range []string{"1 0 a\n", "1 0 a yes\n"} {
+		if _, err := ReadQrels(strings.NewReader(contents)); err == nil {
+			t.Errorf("Expected an error reading qrels %q", contents)
+		}
+	}
+}
+
+func TestEvaluateRanking(t *testing.T) {
+	judgments := map[string]int{"a": 2, "b": 1, "c": 0, "d": 1}
+	q := evaluateRanking([]string{"x", "a", "a", "c", "b"}, judgments, 2)
+	idcg := 2 + 1/math.Log2(3) + 0.5
+	expected := QueryEvaluation{Retrieved: 4, Relevant: 3, RelevantRetrieved: 2, Metrics: Metrics{
+		PrecisionAtK:     0.5,
+		Recall:           2.0 / 3,
+		AveragePrecision: (1.0/2 + 2.0/4) / 3,
+		ReciprocalRank:   0.5,
+		NDCG:             (2/math.Log2(3) + 1/math.Log2(5)) / idcg,
+	}}
+	assertEvaluation(t, q, expected)
+
+	q = evaluateRanking([]string{}, judgments, 10)
+	assertEvaluation(t, q, QueryEvaluation{Relevant: 3})
+	q = evaluateRanking([]string{"a", "b", "d"}, judgments, 3)
+	assertEvaluation(t, q, QueryEvaluation{Retrieved: 3, Relevant: 3, RelevantRetrieved: 3,
+		Metrics: Metrics{PrecisionAtK: 1, Recall: 1, AveragePrecision: 1, ReciprocalRank: 1, NDCG: 1}})
+}
+
+func TestEvaluate(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	qrels := Qrels{
+		"1": {filepath.Join(indexpath, "unique.txt"): 1},
+		"2": {filepath.Join(indexpath, "mixed.txt"): 1},
+		"3": {filepath.Join(indexpath, "mixed.txt"): 0},
+	}
+	topics := []Topic{{"1", "alpha"}, {"2", "delta"}, {"3", "beta"}, {"4", "beta"}, {"2", "/(/"}}
+	e := indexer.Evaluate(topics, qrels, 2)
+	if len(e.Queries) != 3 || !reflect.DeepEqual(e.Unjudged, []string{"3", "4"}) {
+		t.Fatalf("Expected 3 queries evaluated and topics 3 and 4 left out, actual: %+v", e)
+	}
+	assertEvaluation(t, e.Queries[0], QueryEvaluation{Topic: "1", Query: "alpha", Retrieved: 3,
+		Relevant: 1, RelevantRetrieved: 1, Metrics: Metrics{Recall: 1, AveragePrecision: 1.0 / 3,
+			ReciprocalRank: 1.0 / 3, NDCG: 0.5}})
+	assertEvaluation(t, e.Queries[1], QueryEvaluation{Topic: "2", Query: "delta", Retrieved: 1,
+		Relevant: 1, RelevantRetrieved: 1, Metrics: Metrics{PrecisionAtK: 0.5, Recall: 1,
+			AveragePrecision: 1, ReciprocalRank: 1, NDCG: 1}})
+	if q := e.Queries[2]; q.Error == "" || q.Retrieved != 0 {
+		t.Errorf("Expected an invalid query to rank nothing and give an error, actual: %+v", q)
+	}
+	expected := Metrics{PrecisionAtK: 0.5 / 3, Recall: 2.0 / 3, AveragePrecision: (1.0/3 + 1) / 3,
+		ReciprocalRank: (1.0/3 + 1) / 3, NDCG: 0.5}
+	assertEvaluation(t, QueryEvaluation{Metrics: e.Mean}, QueryEvaluation{Metrics: expected})
+}
+
+// assertEvaluation checks an evaluation is as expected, its metrics to
+// within rounding
+func assertEvaluation(t *testing.T, actual, expected QueryEvaluation) {
+	a, e := actual.Metrics, expected.Metrics
+	for _, pair := range [][2]float64{{a.PrecisionAtK, e.PrecisionAtK}, {a.Recall, e.Recall},
+		{a.AveragePrecision, e.AveragePrecision}, {a.ReciprocalRank, e.ReciprocalRank}, {a.NDCG, e.NDCG}} {
+		if math.Abs(pair[0]-pair[1]) > 1e-9 {
+			t.Errorf("Expected evaluation: %+v, actual: %+v", expected, actual)
+			return
+		}
+	}
+	actual.Metrics, expected.Metrics = Metrics{}, Metrics{}
+	actual.Error = ""
+	if actual != expected {
+		t.Errorf("Expected evaluation: %+v, actual: %+v", expected, actual)
+	}
+}
@@ -0,0 +1,149 @@
+package invertedindex
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Explanation breaks down the score a ranked search gives a document. The
+// score is the sum of the scores of the clauses of the query, each of which
+// is the clause's boost times the summed scores of the terms it matched in
+// the document.
+type Explanation struct {
+	DocID int     `json:"docID"`
+	Score float64 `json:"score"`
+	// whether the document matches the query; one that does not scores 0
+	Matched bool `json:"matched"`
+	// number of documents of the index, the N of the idf of every term
+	Documents int                 `json:"documents"`
+	Clauses   []ClauseExplanation `json:"clauses"`
+}
+
+// ClauseExplanation is the part of a score contributed by one clause of a
+// query
+type ClauseExplanation struct {
+	// the clause as written in a query, with its field and its terms
+	// normalized
+	Clause  string  `json:"clause"`
+	Matched bool    `json:"matched"`
+	Boost   float64 `json:"boost"`
+	Score   float64 `json:"score"`
+	// the terms the clause matched in the document
+	Terms []TermExplanation `json:"terms"`
+}
+
+// TermExplanation is the score of one term in a document, which is TFWeight
+// * IDF * Norm
+type TermExplanation struct {
+	Term string `json:"term"`
+	// number of occurrences of the term in the document, 1 in fields indexed
+	// without positions, and the weight of that, 1 + ln TF
+	TF       int     `json:"tf"`
+	TFWeight float64 `json:"tfWeight"`
+	// number of documents containing the term, and the inverse document
+	// frequency of the term, ln(1 + N/DF)
+	DF  int     `json:"df"`
+	IDF float64 `json:"idf"`
+	// length normalization of the term's weight; scores are not normalized
+	// by the length of the document, so it is always 1
+	Norm  float64 `json:"norm"`
+	Score float64 `json:"score"`
+}
+
+// String renders the explanation as a tree, each line giving a score and
+// how it was computed from the lines below it
+func (e Explanation) String() string {
+	var buf bytes.Buffer
+	if !e.Matched {
+		fmt.Fprintf(&buf, "0 = no match of document %d, which does not match:\n", e.DocID)
+		for _, c := range e.Clauses {
+			if !c.Matched {
+				fmt.Fprintf(&buf, "  %s\n", c.Clause)
+			}
+		}
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, "%s = document %d, sum of clauses\n", formatScore(e.Score), e.DocID)
+	for _, c := range e.Clauses {
+		fmt.Fprintf(&buf, "  %s = %s, boost %s * sum of terms\n",
+			formatScore(c.Score), c.Clause, formatScore(c.Boost))
+		for _, t := range c.Terms {
+			fmt.Fprintf(&buf, "    %s = %s: tf weight %s (1 + ln tf %d) * idf %s (ln(1 + N %d / df %d)) * norm %s\n",
+				formatScore(t.Score), t.Term, formatScore(t.TFWeight), t.TF,
+				formatScore(t.IDF), e.Documents, t.DF, formatScore(t.Norm))
+		}
+	}
+	return buf.String()
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(f, 'g', 4, 64)
+}
+
+// Explain breaks down the score RankedSearch gives the document with the
+// passed docID for query. A document not matching the query is explained
+// too, scoring 0, with the clauses it fails to match marked as such.
+func (i *Indexer) Explain(query string, docID int) (Explanation, error) {
+	if _, ok := i.Path(docID); !ok {
+		return Explanation{}, fmt.Errorf("no document with docID %d", docID)
+	}
+	clauses, fields, err := i.prepare(query)
+	if err != nil {
+		return Explanation{}, err
+	}
+	stats := queryStats(clauses, fields)
+	stats.documents = i.documentCount()
+	e := explainDocument(clauses, fields, docID, stats)
+	if err := i.diskError(); err != nil {
+		return Explanation{}, err
+	}
+	return e, nil
+}
+
+// explainDocument explains the score of the document with the passed docID,
+// checking which clauses it matches
+func explainDocument(clauses []fieldClause, fields []*field, docID int, stats collectionStats) Explanation {
+	e := explain(clauses, fields, docID, stats)
+	for k, c := range clauses {
+		docIDs := c.docIDs(fields[k])
+		if j := sort.SearchInts(docIDs, docID); j == len(docIDs) || docIDs[j] != docID {
+			e.Clauses[k].Matched = false
+			e.Matched = false
+		}
+	}
+	if !e.Matched {
+		e.Score = 0
+	}
+	return e
+}
+
+// explain scores the document with the passed docID, which must match every
+// clause, keeping every part of the score
+func explain(clauses []fieldClause, fields []*field, docID int, stats collectionStats) Explanation {
+	e := Explanation{DocID: docID, Matched: true, Documents: stats.documents,
+		Clauses: make([]ClauseExplanation, len(clauses))}
+	for k, c := range clauses {
+		ce := ClauseExplanation{Clause: c.describe(fields[k]), Matched: true,
+			Boost: c.weight(), Terms: []TermExplanation{}}
+		sum := 0.0
+		for _, term := range c.matchedTerms(fields[k]) {
+			tf := termFrequency(fields[k], term, docID)
+			if tf == 0 {
+				continue
+			}
+			key := termKey{c.field, term}
+			t := TermExplanation{Term: term, TF: tf, TFWeight: 1 + math.Log(float64(tf)),
+				DF: stats.documentFrequency[key], IDF: stats.idf(key), Norm: 1}
+			t.Score = t.TFWeight * t.IDF * t.Norm
+			sum += t.Score
+			ce.Terms = append(ce.Terms, t)
+		}
+		ce.Score = ce.Boost * sum
+		e.Score += ce.Score
+		e.Clauses[k] = ce
+	}
+	return e
+}
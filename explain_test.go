@@ -0,0 +1,150 @@
+package invertedindex
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests for boosting clauses and explaining the scores of ranked searches
+
+func TestParseBoosts(t *testing.T) {
+	clauses, err := parseQuery(`a^2 "b c"^0.5 name:d^3 /e/^1.5 f^x g~1^2 x^0 e^-1`, isFileField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fieldClause{
+		{clause: termClause{term: "a"}, field: bodyField, boost: 2},
+		{clause: phraseClause{terms: []string{"b", "c"}}, field: bodyField, boost: 0.5},
+		{clause: termClause{term: "d"}, field: nameField, boost: 3},
+		{clause: clauses[3].clause, field: bodyField, boost: 1.5},
+		// a caret not followed by a number is part of the term
+		{clause: termClause{term: "f^x"}, field: bodyField},
+		{clause: fuzzyClause{term: "g", maxEdits: 1}, field: bodyField, boost: 2},
+		// so is a caret not followed by a positive number
+		{clause: termClause{term: "x^0"}, field: bodyField},
+		{clause: termClause{term: "e^-1"}, field: bodyField},
+	}
+	if !reflect.DeepEqual(clauses, expected) {
+		t.Errorf("Expected clauses: %v, actual: %v", expected, clauses)
+	}
+	for _, query := range []string{`"a b"^0`, `"a b"^x`, `"a b"^`, "a^2 /3 b", "a /3 b^2", "a /3^2 b"} {
+		if _, err := parseQuery(query, isFileField); err == nil {
+			t.Errorf("Expected an error parsing %q", query)
+		}
+	}
+}
+
+func TestBoostedRankedSearch(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	assertRankedSearch(t, indexer, "alpha^2 gamma", []Hit{
+		{DocID: 1, Score: 2*(1+math.Log(2))*math.Log(2) + math.Log(2.5)},
+		{DocID: 2, Score: 2*math.Log(2) + math.Log(2.5)},
+	})
+	// boosts are part of the key the results of a query are cached under
+	assertRankedSearch(t, indexer, "alpha^0.5", []Hit{
+		{DocID: 0, Score: 0.5 * (1 + math.Log(3)) * math.Log(2)},
+		{DocID: 1, Score: 0.5 * (1 + math.Log(2)) * math.Log(2)},
+		{DocID: 2, Score: 0.5 * math.Log(2)},
+	})
+	assertRankedSearch(t, indexer, "alpha", []Hit{
+		{DocID: 0, Score: (1 + math.Log(3)) * math.Log(2)},
+		{DocID: 1, Score: (1 + math.Log(2)) * math.Log(2)},
+		{DocID: 2, Score: math.Log(2)},
+	})
+}
+
+func TestExplain(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	e, err := indexer.Explain(`alpha^2 delta~0`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Explanation{DocID: 1, Matched: true, Documents: 3, Clauses: []ClauseExplanation{
+		{Clause: "body:alpha^2", Matched: true, Boost: 2, Terms: []TermExplanation{
+			{Term: "alpha", TF: 2, TFWeight: 1 + math.Log(2), DF: 3, IDF: math.Log(2), Norm: 1},
+		}},
+		{Clause: "body:delta~0", Matched: true, Boost: 1, Terms: []TermExplanation{
+			{Term: "delta", TF: 2, TFWeight: 1 + math.Log(2), DF: 1, IDF: math.Log(4), Norm: 1},
+		}},
+	}}
+	// scores are checked against those RankedSearch gives
+	e.Score = 0
+	for k := range e.Clauses {
+		e.Clauses[k].Score = 0
+		for j := range e.Clauses[k].Terms {
+			e.Clauses[k].Terms[j].Score = 0
+		}
+	}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("Expected explanation: %+v, actual: %+v", expected, e)
+	}
+
+	for _, query := range []string{"alpha", "alpha^2 gamma", `"alpha beta"^3 name:unique`, "gamm~1 /a.*/"} {
+		hits, err := indexer.RankedSearch(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, hit := range hits {
+			e, err := indexer.Explain(query, hit.DocID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !e.Matched || e.Score != hit.Score {
+				t.Errorf("Expected %q to explain a score of %v for document %d, actual: %+v",
+					query, hit.Score, hit.DocID, e)
+			}
+			sum := 0.0
+			for _, c := range e.Clauses {
+				sum += c.Score
+			}
+			if math.Abs(sum-e.Score) > 1e-9 {
+				t.Errorf("Expected the clauses of %q to sum to %v, actual: %+v", query, e.Score, e)
+			}
+		}
+	}
+}
+
+func TestExplainUnmatched(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	e, err := indexer.Explain("alpha delta", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Matched || e.Score != 0 || !e.Clauses[0].Matched || e.Clauses[1].Matched {
+		t.Errorf("Expected document 0 to only match alpha, actual: %+v", e)
+	}
+	if s := e.String(); !strings.Contains(s, "no match") || !strings.Contains(s, "body:delta") {
+		t.Errorf("Expected the explanation to name the clause not matched, actual: %s", s)
+	}
+	if _, err := indexer.Explain("alpha", 3); err == nil {
+		t.Error("Expected an error explaining a missing document")
+	}
+	if e, err := indexer.Explain("nofield:alpha", 0); err != nil || e.Clauses[0].Clause != "body:nofield:alpha" {
+		t.Errorf("Expected a prefix naming no field to be part of the term, actual: %+v %v", e, err)
+	}
+}
+
+func TestShardedExplain(t *testing.T) {
+	flags := IndexerFlags{Recursive: true}
+	whole := setUpIndexer(t, flags, indexpath)
+	sharded := BuildShardedIndex(flags, indexpath, 3)
+	hits, err := sharded.RankedSearch("alpha gamma^2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wholeHits, _ := whole.RankedSearch("alpha gamma^2")
+	for k, hit := range hits {
+		e, err := sharded.Explain("alpha gamma^2", hit.DocID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := whole.Explain("alpha gamma^2", wholeHits[k].DocID)
+		if math.Abs(e.Score-hit.Score) > 1e-9 || math.Abs(e.Score-expected.Score) > 1e-9 ||
+			e.DocID != hit.DocID || e.Documents != expected.Documents {
+			t.Errorf("Expected the explanation of %v to match the whole index's %+v, actual: %+v",
+				hit, expected, e)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package invertedindex
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// An extractor turns the raw contents of a file into the text of each field
+// it is indexed under
+type extractor func(contents []byte) map[string][]byte
+
+// extractorFor picks the extractor for the file at path, by its extension
+// or, failing that, by sniffing its contents
+func extractorFor(path string, contents []byte) extractor {
+	if isHTML(path, contents) {
+		return extractHTML
+	}
+	return extractPlainText
+}
+
+// isHTML tells whether the file at path is an HTML document, by its
+// extension or, failing that, by sniffing its contents
+func isHTML(path string, contents []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm", ".xhtml":
+		return true
+	case ".txt", ".md":
+		return false
+	}
+	return strings.HasPrefix(http.DetectContentType(contents), "text/html")
+}
+
+// extractPlainText indexes the whole file as the body
+func extractPlainText(contents []byte) map[string][]byte {
+	return map[string][]byte{bodyField: contents}
+}
@@ -0,0 +1,140 @@
+package invertedindex
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// FederatedIndex searches several independent indexes as one, each under a
+// label of its own. Unlike the shards of a ShardedIndex, the indexes know
+// nothing of each other: each scores its documents by its own statistics,
+// so scores are normalized before results are merged.
+type FederatedIndex struct {
+	labels  []string
+	indexes []*Indexer
+}
+
+// FederatedHit is a document matched by a federated search. Documents are
+// told apart by the label of their index along with their docID there, as
+// docIDs of different indexes overlap.
+type FederatedHit struct {
+	Index string `json:"index"`
+	DocID int    `json:"docID"`
+	Path  string `json:"path"`
+	// the score of the document divided by that of the best match of its
+	// index, so that every index's best match scores 1
+	Score float64 `json:"score"`
+	// the score of the document within its index
+	RawScore float64 `json:"rawScore"`
+}
+
+// NewFederatedIndex returns a federated index of no indexes
+func NewFederatedIndex() *FederatedIndex {
+	return new(FederatedIndex)
+}
+
+// Add adds index to those searched, under the passed label
+func (f *FederatedIndex) Add(label string, index *Indexer) error {
+	for _, l := range f.labels {
+		if l == label {
+			return fmt.Errorf("two indexes labeled %s", label)
+		}
+	}
+	f.labels = append(f.labels, label)
+	f.indexes = append(f.indexes, index)
+	return nil
+}
+
+// OpenFederatedIndex opens the indexes built on disk in each of the passed
+// directories, labeling each with its directory
+func OpenFederatedIndex(dirs []string) (*FederatedIndex, error) {
+	f := NewFederatedIndex()
+	for _, dir := range dirs {
+		index, err := OpenIndex(dir)
+		if err == nil {
+			if err = f.Add(filepath.Clean(dir), index); err != nil {
+				index.Close()
+			}
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Close closes the indexes opened from disk
+func (f *FederatedIndex) Close() error {
+	var err error
+	for _, index := range f.indexes {
+		if e := index.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Index returns the index with the passed label, and whether there is one
+func (f *FederatedIndex) Index(label string) (*Indexer, bool) {
+	for k, l := range f.labels {
+		if l == label {
+			return f.indexes[k], true
+		}
+	}
+	return nil, false
+}
+
+// Search runs a ranked search of query against every index in parallel and
+// merges the results by normalized score, then by label and docID. A field
+// only needs to be known to one of the indexes.
+func (f *FederatedIndex) Search(query string) ([]FederatedHit, error) {
+	clauses, fields, err := prepareEach(query, f.indexes)
+	if err != nil {
+		return nil, err
+	}
+	ranked := make([][]Hit, len(f.indexes))
+	parallel(len(f.indexes), func(k int) {
+		if fields[k] == nil {
+			return
+		}
+		stats := queryStats(clauses, fields[k])
+		stats.documents = f.indexes[k].documentCount()
+		ranked[k] = rank(clauses, fields[k], match(clauses, fields[k]), stats)
+	})
+	for _, index := range f.indexes {
+		if err := index.diskError(); err != nil {
+			return nil, err
+		}
+	}
+	hits := []FederatedHit{}
+	for k, index := range f.indexes {
+		for _, hit := range ranked[k] {
+			path, _ := index.Path(hit.DocID)
+			// hits are ordered by score, so the first is the best
+			score := 1.0
+			if best := ranked[k][0].Score; best > 0 {
+				score = hit.Score / best
+			}
+			hits = append(hits, FederatedHit{Index: f.labels[k], DocID: hit.DocID, Path: path,
+				Score: score, RawScore: hit.Score})
+		}
+	}
+	sort.Sort(byNormalizedScore(hits))
+	return hits, nil
+}
+
+type byNormalizedScore []FederatedHit
+
+func (s byNormalizedScore) Len() int      { return len(s) }
+func (s byNormalizedScore) Swap(a, b int) { s[a], s[b] = s[b], s[a] }
+func (s byNormalizedScore) Less(a, b int) bool {
+	if s[a].Score != s[b].Score {
+		return s[a].Score > s[b].Score
+	}
+	if s[a].Index != s[b].Index {
+		return s[a].Index < s[b].Index
+	}
+	return s[a].DocID < s[b].DocID
+}
@@ -0,0 +1,131 @@
+package invertedindex
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests for searching several independent indexes as one
+
+func TestFederatedSearch(t *testing.T) {
+	f := NewFederatedIndex()
+	if err := f.Add("index", setUpIndexer(t, IndexerFlags{}, indexpath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("fields", setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("index", new(Indexer)); err == nil {
+		t.Error("Expected an error adding a second index labeled index")
+	}
+	// every index's best match scores 1, and equal docIDs of different
+	// indexes stay apart
+	assertFederatedHits(t, f, "ext:txt", []FederatedHit{
+		{Index: "fields", DocID: 1, Score: 1},
+		{Index: "index", DocID: 0, Score: 1},
+		{Index: "index", DocID: 1, Score: 1},
+		{Index: "index", DocID: 2, Score: 1},
+	})
+	assertFederatedHits(t, f, "alpha", []FederatedHit{
+		{Index: "index", DocID: 0, Score: 1},
+		{Index: "index", DocID: 1, Score: (1 + math.Log(2)) / (1 + math.Log(3))},
+		{Index: "index", DocID: 2, Score: 1 / (1 + math.Log(3))},
+	})
+	assertFederatedHits(t, f, "install", []FederatedHit{
+		{Index: "fields", DocID: 0, Score: 1},
+		{Index: "fields", DocID: 1, Score: 1},
+	})
+	assertFederatedHits(t, f, "nofield:alpha", []FederatedHit{})
+}
+
+func TestOpenFederatedIndex(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	setUpIndexer(t, IndexerFlags{IndexDir: first}, indexpath)
+	setUpIndexer(t, IndexerFlags{IndexDir: second}, snippetpath)
+	f, err := OpenFederatedIndex([]string{first, second + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hits, err := f.Search("quick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Index != second || filepath.Base(hits[0].Path) != "lorem.txt" ||
+		hits[0].Score != 1 || hits[0].RawScore <= 0 {
+		t.Errorf("Unexpected hits: %+v", hits)
+	}
+	if index, ok := f.Index(first); !ok || index.documentCount() != 3 {
+		t.Errorf("Expected the index labeled %s", first)
+	}
+	if _, err := OpenFederatedIndex([]string{first, "test_files/no_such_index"}); err == nil {
+		t.Error("Expected an error opening a missing index")
+	}
+}
+
+func TestFederatedSearchCorruptIndex(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	setUpIndexer(t, IndexerFlags{IndexDir: first}, indexpath)
+	setUpIndexer(t, IndexerFlags{IndexDir: second}, snippetpath)
+
+	// flipped bits in every posting of the second index, found only once
+	// searched as it is opened lazily
+	rechecksum(t, second, 1, len(postingsMagic))
+	path := filepath.Join(generationDir(second, 1), postingsFile)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := len(postingsMagic); k < len(contents); k++ {
+		contents[k] ^= 1
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFederatedIndex([]string{first, second}); err == nil {
+		t.Error("Expected an error opening an index with no intact commit")
+	}
+
+	f := NewFederatedIndex()
+	defer f.Close()
+	for _, d := range []string{first, second} {
+		index := new(Indexer)
+		if err := index.openIndex(d, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Add(d, index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Search("quick"); err == nil {
+		t.Error("Expected an error searching corrupt postings")
+	}
+	if _, err := f.Search("alpha"); err == nil {
+		t.Error("Expected an error searching once an index was found corrupt")
+	}
+}
+
+func assertFederatedHits(t *testing.T, f *FederatedIndex, query string, expected []FederatedHit) {
+	actual, err := f.Search(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("Expected hits for %q: %+v, actual: %+v", query, expected, actual)
+		return
+	}
+	for k, hit := range actual {
+		e := expected[k]
+		if hit.Index != e.Index || hit.DocID != e.DocID || math.Abs(hit.Score-e.Score) > 1e-9 {
+			t.Errorf("Expected hits for %q: %+v, actual: %+v", query, expected, actual)
+			return
+		}
+	}
+}
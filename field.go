@@ -0,0 +1,279 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Names of the fields every file is indexed under. Queries search the body
+// unless a clause is scoped to another field, e.g. name:readme or ext:go.
+const (
+	bodyField = "body"
+	pathField = "path"
+	nameField = "name"
+	extField  = "ext"
+)
+
+// field holds the postings of one field of the indexed documents
+type field struct {
+	// term -> sorted docIDs of the documents containing the term
+	postings map[string][]int
+	// term -> docID -> occurrences of the term in that document; only fields
+	// indexed with positions have any
+	positions map[string]map[int][]occurrence
+	// whether terms are lowercased when indexed, in which case query terms
+	// searching the field are too
+	foldCase bool
+	// sorted terms of postings; built lazily by dictionary
+	terms []string
+	// trigram -> indexes into terms; built lazily by trigramIndex
+	trigrams map[string][]int
+	// guards the lazy building of terms and trigrams, so that a built index
+	// can be searched concurrently
+	mu sync.Mutex
+	// rough estimate of the memory taken by postings and positions, in bytes
+	size int64
+
+	// the index on disk holding the field when it was opened rather than
+	// built, along with the range of its term entries that are the field's.
+	// The terms looked up are then decoded into the posting cache of the
+	// index, which keeps those of the hot terms, and postings and positions
+	// are left empty unless every term is decoded at once by loadAll.
+	disk       *diskIndex
+	start, end int
+	cache      *lruCache
+	// whether every term has been decoded
+	complete bool
+}
+
+// Rough sizes, in bytes, of the parts of a field's postings and positions,
+// including the overhead of the maps and slices holding them
+const (
+	termSize       = 128
+	postingSize    = 16
+	occurrenceSize = 48
+)
+
+func newField(foldCase bool) *field {
+	return &field{
+		postings:  make(map[string][]int),
+		positions: make(map[string]map[int][]occurrence),
+		foldCase:  foldCase,
+	}
+}
+
+// add records that the document with the passed docID contains term.
+// Documents must be added in increasing docID order.
+func (f *field) add(term string, docID int) {
+	postings, ok := f.postings[term]
+	if !ok {
+		f.terms = nil
+		f.trigrams = nil
+		f.size += termSize + int64(len(term))
+	}
+	if len(postings) == 0 || postings[len(postings)-1] != docID {
+		f.postings[term] = append(postings, docID)
+		f.size += postingSize
+	}
+}
+
+// addOccurrence records an occurrence of term in the document with the
+// passed docID, as well as adding the term to its postings
+func (f *field) addOccurrence(term string, docID int, o occurrence) {
+	f.add(term, docID)
+	if f.positions[term] == nil {
+		f.positions[term] = make(map[int][]occurrence)
+	}
+	f.positions[term][docID] = append(f.positions[term][docID], o)
+	f.size += occurrenceSize
+}
+
+// reset empties the postings and positions of the field
+func (f *field) reset() {
+	f.postings = make(map[string][]int)
+	f.positions = make(map[string]map[int][]occurrence)
+	f.terms = nil
+	f.trigrams = nil
+	f.size = 0
+}
+
+// docIDs returns the sorted docIDs of the documents containing term
+func (f *field) docIDs(term string) []int {
+	docIDs, _ := f.lookup(term)
+	return docIDs
+}
+
+// occurrences returns the occurrences of term in each document containing
+// it, or nil if the field is indexed without positions
+func (f *field) occurrences(term string) map[int][]occurrence {
+	_, occurrences := f.lookup(term)
+	return occurrences
+}
+
+// lookup returns the postings and positions of term, decoding them from
+// disk, or taking them from the posting cache, if the field was opened
+func (f *field) lookup(term string) ([]int, map[int][]occurrence) {
+	if f.disk == nil {
+		return f.postings[term], f.positions[term]
+	}
+	f.mu.Lock()
+	complete := f.complete
+	f.mu.Unlock()
+	if complete {
+		return f.postings[term], f.positions[term]
+	}
+	key := postingKey{f, term}
+	if cached, ok := f.cache.get(key); ok {
+		p := cached.(postingList)
+		return p.docIDs, p.occurrences
+	}
+	var p postingList
+	e, found := f.disk.find(f.start, f.end, term)
+	if found {
+		var err error
+		if p, err = f.decode(e); err != nil {
+			return nil, nil
+		}
+	} else if f.disk.failure() != nil {
+		return nil, nil
+	}
+	f.cache.add(key, p)
+	return p.docIDs, p.occurrences
+}
+
+// decode reads the postings of a term entry. Corrupt postings read as none
+// and fail the index, so that the search reading them reports the error
+// rather than results missing documents.
+func (f *field) decode(e termEntry) (postingList, error) {
+	docIDs, occurrences, err := f.disk.postingsOf(e)
+	if err != nil {
+		f.disk.fail(err)
+		return postingList{}, err
+	}
+	return postingList{docIDs, occurrences}, nil
+}
+
+// loadAll decodes every term of a field opened from disk, for the uses that
+// walk all of its postings
+func (f *field) loadAll() {
+	if f.disk == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.complete {
+		return
+	}
+	for k := f.start; k < f.end; k++ {
+		e, err := f.disk.entry(k)
+		if err != nil {
+			f.disk.fail(err)
+			continue
+		}
+		if p, err := f.decode(e); err == nil && p.docIDs != nil {
+			f.postings[e.term] = p.docIDs
+			if p.occurrences != nil {
+				f.positions[e.term] = p.occurrences
+			}
+		}
+	}
+	f.complete = true
+}
+
+// normalize returns term as it would have been indexed in this field
+func (f *field) normalize(term string) string {
+	if f.foldCase {
+		return strings.ToLower(term)
+	}
+	return term
+}
+
+// dictionary returns the terms of the field in sorted order. The slice is
+// built on first use and must not be modified by the caller.
+func (f *field) dictionary() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sortedTerms()
+}
+
+// sortedTerms builds the dictionary if need be; f.mu must be held
+func (f *field) sortedTerms() []string {
+	if f.terms == nil && f.disk != nil {
+		// the entries of the terms file are sorted already
+		f.terms = make([]string, 0, f.end-f.start)
+		for k := f.start; k < f.end; k++ {
+			e, err := f.disk.entry(k)
+			if err != nil {
+				f.disk.fail(err)
+				continue
+			}
+			f.terms = append(f.terms, e.term)
+		}
+	} else if f.terms == nil {
+		f.terms = make([]string, 0, len(f.postings))
+		for term := range f.postings {
+			f.terms = append(f.terms, term)
+		}
+		sort.Strings(f.terms)
+	}
+	return f.terms
+}
+
+// trigramIndex returns a mapping from every trigram occurring in the
+// dictionary to the sorted indexes of the dictionary terms containing it.
+// Like the dictionary it is built on first use.
+func (f *field) trigramIndex() map[string][]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.trigrams == nil {
+		f.trigrams = make(map[string][]int)
+		for k, term := range f.sortedTerms() {
+			for _, trigram := range trigramsOf(term) {
+				postings := f.trigrams[trigram]
+				if len(postings) == 0 || postings[len(postings)-1] != k {
+					f.trigrams[trigram] = append(postings, k)
+				}
+			}
+		}
+	}
+	return f.trigrams
+}
+
+// pathFieldTerms returns the terms of the path, name and ext fields of the
+// file at path. All of them are lowercased.
+//
+//	path  every element of the path, e.g. docs and readme.md
+//	name  the base name, the name without its extension and the words of
+//	      that, e.g. my_notes.txt, my_notes, my and notes
+//	ext   the extension without its dot, e.g. txt
+func pathFieldTerms(path string) map[string][]string {
+	path = strings.ToLower(filepath.ToSlash(path))
+	// members of archives are indexed as though the archive were a directory
+	path = strings.Replace(path, archiveSeparator, "/", -1)
+	terms := map[string][]string{pathField: {}, nameField: {}, extField: {}}
+	for _, element := range strings.Split(path, "/") {
+		if element != "" && element != "." && element != ".." {
+			terms[pathField] = append(terms[pathField], element)
+		}
+	}
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	terms[nameField] = append(terms[nameField], base)
+	if stem != "" && stem != base {
+		terms[nameField] = append(terms[nameField], stem)
+	}
+	words := strings.FieldsFunc(stem, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 1 {
+		terms[nameField] = append(terms[nameField], words...)
+	}
+	if len(ext) > 1 {
+		terms[extField] = append(terms[extField], ext[1:])
+	}
+	return terms
+}
@@ -0,0 +1,108 @@
+package invertedindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for indexing the path, name and extension of files as fields and
+// scoping queries to them. The documents of test_files/field_files are
+// README.md, notes/my_notes.txt and src/lib.rs with docIDs 0, 1 and 2
+
+var fieldpath string = "test_files/field_files"
+
+func setUpFieldIndexer(t *testing.T) *Indexer {
+	return setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath)
+}
+
+func TestPathFieldTerms(t *testing.T) {
+	actual := pathFieldTerms(filepath.Join("Docs", "My_Notes-2014.TXT"))
+	expected := map[string][]string{
+		pathField: {"docs", "my_notes-2014.txt"},
+		nameField: {"my_notes-2014.txt", "my_notes-2014", "my", "notes", "2014"},
+		extField:  {"txt"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected field terms: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestPathFieldTermsWithoutExtension(t *testing.T) {
+	actual := pathFieldTerms("Makefile")
+	expected := map[string][]string{
+		pathField: {"makefile"},
+		nameField: {"makefile"},
+		extField:  {},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected field terms: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestSearchNameField(t *testing.T) {
+	indexer := setUpFieldIndexer(t)
+	assertSearchResult(t, indexer, "name:readme", []int{0})
+	assertSearchResult(t, indexer, "name:README.md", []int{0})
+	assertSearchResult(t, indexer, "name:notes", []int{1})
+	assertSearchResult(t, indexer, "name:install", []int{})
+}
+
+func TestSearchExtensionField(t *testing.T) {
+	indexer := setUpFieldIndexer(t)
+	assertSearchResult(t, indexer, "ext:rs", []int{2})
+	assertSearchResult(t, indexer, "ext:/md|txt/", []int{0, 1})
+}
+
+func TestSearchPathField(t *testing.T) {
+	indexer := setUpFieldIndexer(t)
+	assertSearchResult(t, indexer, "path:field_files", []int{0, 1, 2})
+	assertSearchResult(t, indexer, "path:src", []int{2})
+	assertSearchResult(t, indexer, "path:notez~1", []int{1})
+}
+
+func TestSearchBodyAndOtherFields(t *testing.T) {
+	indexer := setUpFieldIndexer(t)
+	assertSearchResult(t, indexer, "install", []int{0, 1})
+	assertSearchResult(t, indexer, "body:install", []int{0, 1})
+	assertSearchResult(t, indexer, "name:readme body:install ext:md", []int{0})
+	assertSearchResult(t, indexer, `ext:txt body:"the tool"`, []int{1})
+	assertSearchResult(t, indexer, "ext:rs install", []int{})
+}
+
+func TestSearchUnknownField(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	contents := []byte("TODO:fix the link to http://host\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexer := setUpIndexer(t, IndexerFlags{}, dir)
+	// a prefix that names no field is part of the term
+	assertSearchResult(t, indexer, "TODO:fix", []int{0})
+	assertSearchResult(t, indexer, "http://host link", []int{0})
+	assertSearchResult(t, indexer, "author:killeent", []int{})
+	assertSearchResult(t, indexer, "ext:txt", []int{0})
+}
+
+func TestParseFieldScopes(t *testing.T) {
+	clauses, err := parseQuery(`name:a b: path:"c d" ext:/e/ :f`, isFileField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fieldClause{
+		{clause: termClause{term: "a"}, field: nameField},
+		{clause: termClause{term: "b:"}, field: bodyField},
+		{clause: phraseClause{terms: []string{"c", "d"}}, field: pathField},
+		{clause: clauses[3].clause, field: extField},
+		{clause: termClause{term: ":f"}, field: bodyField},
+	}
+	if !reflect.DeepEqual(clauses, expected) {
+		t.Errorf("Expected clauses: %v, actual: %v", expected, clauses)
+	}
+	if _, ok := clauses[3].clause.(regexClause); !ok {
+		t.Errorf("Expected regular expression clause, actual: %v", clauses[3].clause)
+	}
+}
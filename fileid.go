@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package invertedindex
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKey identifies a file or directory however many paths lead to it
+type fileKey struct {
+	dev, ino uint64
+}
+
+// fileKeyOf returns the key of the file described by info, found at path,
+// and whether it could be told
+func fileKeyOf(info os.FileInfo, path string) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,29 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package invertedindex
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileKey identifies a file or directory however many paths lead to it. The
+// device and inode are not at hand here, so the real path is used instead.
+type fileKey struct {
+	path string
+}
+
+// fileKeyOf returns the key of the file described by info, found at path,
+// and whether it could be told
+func fileKeyOf(info os.FileInfo, path string) (fileKey, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fileKey{}, false
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return fileKey{}, false
+	}
+	return fileKey{path: real}, true
+}
@@ -0,0 +1,116 @@
+package invertedindex
+
+import (
+	"sort"
+	"strings"
+)
+
+// levenshteinAutomaton accepts every string within maxEdits insertions,
+// deletions or substitutions of term. Rather than compiling a DFA up front
+// the automaton is simulated: a state is a row of the edit distance matrix
+// between term and the input consumed so far, with entries capped at
+// maxEdits+1 so that equivalent states compare equal.
+type levenshteinAutomaton struct {
+	term     []rune
+	maxEdits int
+}
+
+func newLevenshteinAutomaton(term string, maxEdits int) *levenshteinAutomaton {
+	return &levenshteinAutomaton{term: []rune(term), maxEdits: maxEdits}
+}
+
+// start returns the state of the automaton before any input is consumed
+func (a *levenshteinAutomaton) start() []int {
+	state := make([]int, len(a.term)+1)
+	for i := range state {
+		state[i] = a.cap(i)
+	}
+	return state
+}
+
+// step returns the state reached by consuming r in the passed state
+func (a *levenshteinAutomaton) step(state []int, r rune) []int {
+	next := make([]int, len(state))
+	next[0] = a.cap(state[0] + 1)
+	for i := 1; i < len(state); i++ {
+		cost := 1
+		if a.term[i-1] == r {
+			cost = 0
+		}
+		next[i] = a.cap(min(state[i-1]+cost, state[i]+1, next[i-1]+1))
+	}
+	return next
+}
+
+// isMatch reports whether the input consumed to reach state is within
+// maxEdits of the term
+func (a *levenshteinAutomaton) isMatch(state []int) bool {
+	return state[len(state)-1] <= a.maxEdits
+}
+
+// canMatch reports whether any continuation of the input consumed to reach
+// state could still be within maxEdits of the term. Once this is false the
+// state is dead and no string with the consumed prefix can match.
+func (a *levenshteinAutomaton) canMatch(state []int) bool {
+	for _, d := range state {
+		if d <= a.maxEdits {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *levenshteinAutomaton) cap(d int) int {
+	if d > a.maxEdits {
+		return a.maxEdits + 1
+	}
+	return d
+}
+
+// intersect walks the automaton against a sorted dictionary and returns the
+// terms it accepts, in dictionary order. States are kept on a stack indexed by
+// prefix length so neighbouring terms only pay for the runes after their
+// shared prefix, and whenever a prefix drives the automaton into a dead state
+// every term sharing that prefix is skipped with a binary search.
+func (a *levenshteinAutomaton) intersect(terms []string) []string {
+	matches := []string{}
+	states := [][]int{a.start()}
+	var prev []rune
+	for k := 0; k < len(terms); {
+		word := []rune(terms[k])
+		common := commonPrefixLength(prev, word)
+		states = states[:common+1]
+		dead := false
+		for d := common; d < len(word); d++ {
+			next := a.step(states[d], word[d])
+			states = append(states, next)
+			if !a.canMatch(next) {
+				prefix := string(word[:d+1])
+				rest := terms[k:]
+				k += sort.Search(len(rest), func(j int) bool {
+					return !strings.HasPrefix(rest[j], prefix)
+				})
+				word = word[:d+1]
+				dead = true
+				break
+			}
+		}
+		prev = word
+		if !dead {
+			if a.isMatch(states[len(word)]) {
+				matches = append(matches, terms[k])
+			}
+			k++
+		}
+	}
+	return matches
+}
+
+// commonPrefixLength returns the number of leading runes a and b share
+func commonPrefixLength(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
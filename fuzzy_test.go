@@ -0,0 +1,84 @@
+package invertedindex
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// editDistance computes the Levenshtein distance between a and b directly;
+// used to check the automaton against a brute force scan
+func editDistance(a, b string) int {
+	r1, r2 := []rune(a), []rune(b)
+	row := make([]int, len(r2)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(r1); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(r2); j++ {
+			cost := 1
+			if r1[i-1] == r2[j-1] {
+				cost = 0
+			}
+			cur := min(prev+cost, row[j]+1, row[j-1]+1)
+			prev = row[j]
+			row[j] = cur
+		}
+	}
+	return row[len(r2)]
+}
+
+func assertEqualTerms(t *testing.T, actual, expected []string) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected terms: %v, actual: %v", expected, actual)
+	}
+}
+
+var fuzzyDictionary = []string{"", "a", "alp", "alpha", "alphabet", "alpine", "beta",
+	"colour", "color", "colder", "gamma", "grammar", "kolor", "zeta", "ñandu", "nandu"}
+
+// tests that a distance of zero only accepts the term itself
+func TestFuzzyExactMatch(t *testing.T) {
+	terms := append([]string{}, fuzzyDictionary...)
+	sort.Strings(terms)
+	assertEqualTerms(t, newLevenshteinAutomaton("alpha", 0).intersect(terms), []string{"alpha"})
+}
+
+// tests insertions, deletions and substitutions within a single edit
+func TestFuzzySingleEdit(t *testing.T) {
+	terms := append([]string{}, fuzzyDictionary...)
+	sort.Strings(terms)
+	assertEqualTerms(t, newLevenshteinAutomaton("color", 1).intersect(terms),
+		[]string{"color", "colour", "kolor"})
+}
+
+// tests that edits are counted in runes rather than bytes
+func TestFuzzyMultiByteRunes(t *testing.T) {
+	terms := append([]string{}, fuzzyDictionary...)
+	sort.Strings(terms)
+	assertEqualTerms(t, newLevenshteinAutomaton("nandu", 1).intersect(terms),
+		[]string{"nandu", "ñandu"})
+}
+
+// tests that walking the automaton agrees with computing the edit distance
+// to every term in the dictionary
+func TestFuzzyMatchesBruteForce(t *testing.T) {
+	terms := append([]string{}, fuzzyDictionary...)
+	sort.Strings(terms)
+	for _, query := range []string{"", "alpha", "colr", "gamma", "zzz", "ñ"} {
+		for n := 0; n <= 3; n++ {
+			expected := []string{}
+			for _, term := range terms {
+				if editDistance(query, term) <= n {
+					expected = append(expected, term)
+				}
+			}
+			actual := newLevenshteinAutomaton(query, n).intersect(terms)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s~%d expected: %v, actual: %v", query, n, expected, actual)
+			}
+		}
+	}
+}
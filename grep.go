@@ -0,0 +1,75 @@
+package invertedindex
+
+import (
+	"bytes"
+)
+
+// A LineHit is a line of a document containing at least one match of a
+// query, as printed by grep
+type LineHit struct {
+	// Line and Column locate the first match on the line; both count from 1
+	// and Column counts bytes
+	Line, Column int
+	// Text is the line without its line terminator
+	Text string
+	// Matches are the byte ranges [start, end) of the matches within Text. A
+	// match spanning several lines is split between them, each line showing
+	// its own part of the match.
+	Matches [][2]int
+}
+
+// LineHits returns every line of the document with the passed docID that
+// query matched, in document order. Matches are located with the line and
+// column recorded for each token during indexing, and the lines themselves
+// are re-read from the document's file. Those of an HTML document are lines
+// of its markup, matches being mapped back from the text extracted from it.
+func (i *Indexer) LineHits(query string, docID int) ([]LineHit, error) {
+	contents, spans, source, err := i.documentMatches(query, docID)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		contents, spans = source.contents, source.locate(spans)
+	}
+	hits := []LineHit{}
+	for _, s := range mergeSpans(contents, spans) {
+		lineStart := s.start - (s.column - 1)
+		if lineStart < 0 {
+			continue
+		}
+		// every line the match touches shows the part of it on that line
+		for line := s.line; lineStart < s.end; line++ {
+			text := lineAt(contents, lineStart)
+			start, end := s.start-lineStart, s.end-lineStart
+			if start < 0 {
+				start = 0
+			}
+			if end > len(text) {
+				end = len(text)
+			}
+			if start < end {
+				if n := len(hits); n == 0 || hits[n-1].Line != line {
+					hits = append(hits, LineHit{Line: line, Column: start + 1, Text: string(text)})
+				}
+				hit := &hits[len(hits)-1]
+				hit.Matches = append(hit.Matches, [2]int{start, end})
+			}
+			next := bytes.IndexByte(contents[lineStart:], '\n')
+			if next < 0 {
+				break
+			}
+			lineStart += next + 1
+		}
+	}
+	return hits, nil
+}
+
+// lineAt returns the line of text starting at the passed offset, without its
+// "\n" or "\r\n" terminator
+func lineAt(text []byte, start int) []byte {
+	line := text[start:]
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
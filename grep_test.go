@@ -0,0 +1,63 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for finding the lines of a document a query matched
+
+func assertLineHits(t *testing.T, query string, expected []LineHit) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(snippetpath, "lorem.txt"))
+	actual, err := indexer.LineHits(query, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("query %q expected line hits: %v, actual: %v", query, expected, actual)
+	}
+}
+
+func TestLineHitsOfTerm(t *testing.T) {
+	assertLineHits(t, "jump!", []LineHit{
+		{Line: 2, Column: 56, Text: "five dozen liquor jugs. How vexingly quick daft zebras jump!",
+			Matches: [][2]int{{55, 60}}},
+	})
+}
+
+func TestLineHitsSeveralMatchesOnALine(t *testing.T) {
+	assertLineHits(t, "quick The", []LineHit{
+		{Line: 1, Column: 1, Text: "The quick brown fox jumps over the lazy dog. Pack my box with",
+			Matches: [][2]int{{0, 3}, {4, 9}}},
+		{Line: 2, Column: 38, Text: "five dozen liquor jugs. How vexingly quick daft zebras jump!",
+			Matches: [][2]int{{37, 42}}},
+		{Line: 3, Column: 1, Text: "The five boxing wizards jump quickly, and the quick brown fox",
+			Matches: [][2]int{{0, 3}, {46, 51}}},
+	})
+}
+
+func TestLineHitsOfPhraseAcrossLines(t *testing.T) {
+	assertLineHits(t, `"fox sleeps."`, []LineHit{
+		{Line: 3, Column: 59, Text: "The five boxing wizards jump quickly, and the quick brown fox",
+			Matches: [][2]int{{58, 61}}},
+		{Line: 4, Column: 1, Text: "sleeps.", Matches: [][2]int{{0, 7}}},
+	})
+}
+
+func TestLineHitsOfMergedMatchesAcrossLines(t *testing.T) {
+	// the match of sleeps. is absorbed by that of the phrase, but its line
+	// is still reported
+	assertLineHits(t, `"fox sleeps." sleeps.`, []LineHit{
+		{Line: 3, Column: 59, Text: "The five boxing wizards jump quickly, and the quick brown fox",
+			Matches: [][2]int{{58, 61}}},
+		{Line: 4, Column: 1, Text: "sleeps.", Matches: [][2]int{{0, 7}}},
+	})
+}
+
+func TestLineHitsOfProximity(t *testing.T) {
+	assertLineHits(t, "fox /3 over", []LineHit{
+		{Line: 1, Column: 17, Text: "The quick brown fox jumps over the lazy dog. Pack my box with",
+			Matches: [][2]int{{16, 19}, {26, 30}}},
+	})
+}
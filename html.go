@@ -0,0 +1,229 @@
+package invertedindex
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strings"
+)
+
+// Fields HTML documents are indexed under besides the body
+const (
+	titleField   = "title"
+	headingField = "heading"
+)
+
+// blockElements are the elements that separate the text before them from the
+// text after them, so that "a</p><p>b" is read as two words rather than one
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "td": true, "th": true,
+	"title": true, "tr": true, "ul": true,
+}
+
+// extractHTML strips the markup from an HTML document and returns its text.
+// The contents of script and style elements are dropped, entities are
+// decoded, and besides the body the text of the title is kept as the title
+// field and the text of h1 to h6 headings as the heading field. The title is
+// not part of the body, but headings are.
+func extractHTML(contents []byte) map[string][]byte {
+	fields, _ := extractHTMLOffsets(contents)
+	return fields
+}
+
+// extractHTMLOffsets extracts the text of an HTML document like extractHTML,
+// also returning where in the document every part of the body came from
+func extractHTMLOffsets(contents []byte) (map[string][]byte, sourceMap) {
+	var body, title, heading bytes.Buffer
+	var offsets sourceMap
+	inTitle, inHeading := false, false
+	for k := 0; k < len(contents); {
+		if contents[k] != '<' {
+			end := bytes.IndexByte(contents[k+1:], '<') + 1
+			if end == 0 {
+				end = len(contents) - k
+			}
+			if inTitle {
+				title.WriteString(html.UnescapeString(string(contents[k : k+end])))
+			} else {
+				start := body.Len()
+				offsets.writeText(&body, contents[k:k+end], k)
+				if inHeading {
+					heading.Write(body.Bytes()[start:])
+				}
+			}
+			k += end
+			continue
+		}
+		rest := contents[k:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			k += skipPast(rest, "-->")
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("<?")):
+			k += skipPast(rest, ">")
+		default:
+			name, closing, n := parseTag(rest)
+			if n == 0 {
+				// a lone '<' is text
+				offsets.add(body.Len(), k, k+1, true)
+				body.WriteByte('<')
+				k++
+				continue
+			}
+			k += n
+			switch {
+			case (name == "script" || name == "style") && !closing:
+				k += skipPastClosingTag(contents[k:], name)
+			case name == "title":
+				inTitle = !closing
+			case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+				inHeading = !closing
+				if closing {
+					heading.WriteByte('\n')
+				}
+			}
+			if blockElements[name] {
+				offsets.add(body.Len(), k-n, k, false)
+				body.WriteByte('\n')
+			}
+		}
+	}
+	return map[string][]byte{bodyField: body.Bytes(), titleField: title.Bytes(),
+		headingField: heading.Bytes()}, offsets
+}
+
+// sourceMap maps offsets in the text extracted from a document back to
+// offsets in the document. Its runs are in order and cover the text.
+type sourceMap []sourceRun
+
+// sourceRun is a run of extracted text starting at the offset start, which
+// stands for the bytes [source, sourceEnd) of the document. An exact run is
+// a copy of those bytes; any other stands for them as a whole, like a
+// decoded entity or the line break of a block element.
+type sourceRun struct {
+	start             int
+	source, sourceEnd int
+	exact             bool
+}
+
+// add appends a run starting at the offset start of the text
+func (m *sourceMap) add(start, source, sourceEnd int, exact bool) {
+	*m = append(*m, sourceRun{start, source, sourceEnd, exact})
+}
+
+// writeText decodes the entities of raw, which starts at the offset source
+// of the document, and writes the text to buf, mapping every part of it
+func (m *sourceMap) writeText(buf *bytes.Buffer, raw []byte, source int) {
+	// an entity does not contain '&', so every piece starting at one can be
+	// decoded on its own
+	for len(raw) > 0 {
+		n := bytes.IndexByte(raw[1:], '&') + 1
+		if n == 0 {
+			n = len(raw)
+		}
+		piece := string(raw[:n])
+		text := html.UnescapeString(piece)
+		if text == piece {
+			m.add(buf.Len(), source, source+n, true)
+		} else {
+			// the entity the piece starts with, then text copied as is
+			tail := 0
+			for tail < len(text) && tail < n && text[len(text)-1-tail] == piece[n-1-tail] {
+				tail++
+			}
+			m.add(buf.Len(), source, source+n-tail, false)
+			if tail > 0 {
+				m.add(buf.Len()+len(text)-tail, source+n-tail, source+n, true)
+			}
+		}
+		buf.WriteString(text)
+		raw, source = raw[n:], source+n
+	}
+}
+
+// toSource returns the offset in the document of the passed offset of the
+// text, taken as the end of a range of the text if end is set
+func (m sourceMap) toSource(offset int, end bool) int {
+	at := offset
+	if end {
+		at--
+	}
+	k := sort.Search(len(m), func(k int) bool { return m[k].start > at }) - 1
+	if k < 0 {
+		return 0
+	}
+	r := m[k]
+	switch {
+	case r.exact:
+		return r.source + offset - r.start
+	case end:
+		return r.sourceEnd
+	}
+	return r.source
+}
+
+// parseTag parses the start or end tag at the start of s and returns its
+// lowercased element name, whether it is an end tag and its length in bytes.
+// The length is 0 if s does not start with a tag.
+func parseTag(s []byte) (string, bool, int) {
+	k := 1
+	closing := k < len(s) && s[k] == '/'
+	if closing {
+		k++
+	}
+	start := k
+	for k < len(s) && (isASCIILetter(s[k]) || (k > start && s[k] >= '0' && s[k] <= '9')) {
+		k++
+	}
+	if k == start {
+		return "", false, 0
+	}
+	name := strings.ToLower(string(s[start:k]))
+	// skip attributes, minding that quoted values may contain '>'
+	var quote byte
+	for ; k < len(s); k++ {
+		switch {
+		case quote != 0:
+			if s[k] == quote {
+				quote = 0
+			}
+		case s[k] == '"' || s[k] == '\'':
+			quote = s[k]
+		case s[k] == '>':
+			return name, closing, k + 1
+		}
+	}
+	return name, closing, len(s)
+}
+
+// skipPast returns the number of bytes of s up to and including the first
+// occurrence of end, or all of s if there is none
+func skipPast(s []byte, end string) int {
+	if k := bytes.Index(s, []byte(end)); k >= 0 {
+		return k + len(end)
+	}
+	return len(s)
+}
+
+// skipPastClosingTag returns the number of bytes of s up to and including
+// the end tag of the named element, or all of s if there is none
+func skipPastClosingTag(s []byte, name string) int {
+	for k := 0; ; k += 2 {
+		j := bytes.Index(s[k:], []byte("</"))
+		if j < 0 {
+			return len(s)
+		}
+		k += j
+		if end := k + 2 + len(name); end <= len(s) && bytes.EqualFold(s[k+2:end], []byte(name)) {
+			_, _, n := parseTag(s[k:])
+			return k + n
+		}
+	}
+}
+
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
@@ -0,0 +1,100 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for extracting the text of HTML documents
+
+var htmlpath string = "test_files/html_files"
+
+func assertExtractedField(t *testing.T, fields map[string][]byte, name, expected string) {
+	if actual := string(fields[name]); actual != expected {
+		t.Errorf("Expected %s field: %q, actual: %q", name, expected, actual)
+	}
+}
+
+func TestExtractHTMLStripsTags(t *testing.T) {
+	fields := extractHTML([]byte(`<p class="x">alpha <b>be</b>ta</p><p>gamma</p>`))
+	assertExtractedField(t, fields, bodyField, "\nalpha beta\n\ngamma\n")
+}
+
+func TestExtractHTMLDropsScriptAndStyle(t *testing.T) {
+	fields := extractHTML([]byte("a<script>if (x < y) { b() }</SCRIPT>c<style>p { d: e }</style>f"))
+	assertExtractedField(t, fields, bodyField, "acf")
+}
+
+func TestExtractHTMLDecodesEntities(t *testing.T) {
+	fields := extractHTML([]byte("fish &amp; chips &lt;3 &eacute;t&#233; &bogus;"))
+	assertExtractedField(t, fields, bodyField, "fish & chips <3 été &bogus;")
+}
+
+func TestExtractHTMLKeepsLoneAngleBrackets(t *testing.T) {
+	fields := extractHTML([]byte("1 < 2 and 3 <= 4 <!-- hidden -->"))
+	assertExtractedField(t, fields, bodyField, "1 < 2 and 3 <= 4 ")
+}
+
+func TestExtractHTMLTitleAndHeadings(t *testing.T) {
+	fields := extractHTML([]byte("<title>The Title</title><h1>One</h1><p>text</p><H2 id='a>b'>Two</H2>"))
+	assertExtractedField(t, fields, titleField, "The Title")
+	assertExtractedField(t, fields, headingField, "One\nTwo\n")
+	assertExtractedField(t, fields, bodyField, "\n\n\nOne\n\ntext\n\nTwo\n")
+}
+
+func TestExtractorSelection(t *testing.T) {
+	html := []byte("<html><body>hi</body></html>")
+	if _, ok := extractorFor("page.HTM", []byte("plain"))(nil)[titleField]; !ok {
+		t.Error("Expected HTML extractor for .htm file")
+	}
+	if _, ok := extractorFor("page", html)(html)[titleField]; !ok {
+		t.Error("Expected HTML extractor for sniffed HTML")
+	}
+	if _, ok := extractorFor("notes.txt", html)(html)[titleField]; ok {
+		t.Error("Expected plain text extractor for .txt file")
+	}
+	if _, ok := extractorFor("data", []byte("alpha beta"))([]byte("alpha beta"))[titleField]; ok {
+		t.Error("Expected plain text extractor for plain text")
+	}
+}
+
+func TestSearchHTMLDocuments(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, htmlpath)
+	assertSearchResult(t, indexer, "download", []int{0})
+	assertSearchResult(t, indexer, "£5,", []int{0})
+	assertSearchResult(t, indexer, "&", []int{0})
+	assertSearchResult(t, indexer, "title:Install", []int{0})
+	assertSearchResult(t, indexer, "heading:steps", []int{0})
+	assertSearchResult(t, indexer, "sniffed", []int{1})
+	for _, query := range []string{"secret", "color:", "href", "class", "<p>", "Install"} {
+		assertSearchResult(t, indexer, query, []int{})
+	}
+}
+
+func TestSnippetsOfHTMLDocument(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(htmlpath, "page.html"))
+	snippets, err := indexer.Snippets("download", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{
+		"started\n\n\t\nFish & chips cost £5, [download] here.\n\n\t\nNext steps\n\n\t\nRun it\n\n\n\n"})
+}
+
+func TestLineHitsOfHTMLDocument(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(htmlpath, "page.html"))
+	hits, err := indexer.LineHits("download £5, & steps", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lines and columns are those of the markup, entities included
+	expected := []LineHit{
+		{Line: 11, Column: 10, Text: "\t<p>Fish &amp; chips cost &pound;5, <a href=\"/install?a=1&amp;b=2\" " +
+			"title=\"x > y\">download</a> here.</p>", Matches: [][2]int{{9, 14}, {26, 35}, {81, 89}}},
+		{Line: 12, Column: 11, Text: "\t<h2>Next steps</h2>", Matches: [][2]int{{10, 15}}},
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Errorf("Expected line hits: %v, actual: %v", expected, hits)
+	}
+}
@@ -0,0 +1,190 @@
+package invertedindex
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFiles are the names of the files whose patterns are respected by a
+// crawl with the RespectIgnore flag, in the order they are read
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// globPattern is a compiled pattern in the syntax of .gitignore files:
+//
+//	?       any character other than '/'
+//	*       any run of characters other than '/'
+//	[a-z]   any character in the class; [!a-z] negates it
+//	**      any number of directories when it makes up a whole element,
+//	        e.g. **/build, docs/**/*.txt or vendor/**
+//	!p      a pattern p whose matches are not ignored after all
+//	p/      a pattern p that only matches directories
+//
+// A pattern containing a slash other than a trailing one is matched against
+// the whole path relative to its base; any other pattern is matched against
+// the name of the file or directory only.
+type globPattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// compileGlob compiles a pattern as described by globPattern
+func compileGlob(pattern string) (*globPattern, error) {
+	g := new(globPattern)
+	if strings.HasPrefix(pattern, "!") {
+		g.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		g.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	expr := globToRegexp(pattern)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	g.re = re
+	return g, nil
+}
+
+// globToRegexp translates the wildcards of a glob to a regular expression
+func globToRegexp(glob string) string {
+	var expr bytes.Buffer
+	elements := strings.Split(glob, "/")
+	for k, element := range elements {
+		last := k == len(elements)-1
+		if element == "**" {
+			if last {
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+		for j := 0; j < len(element); j++ {
+			switch c := element[j]; c {
+			case '*':
+				expr.WriteString("[^/]*")
+			case '?':
+				expr.WriteString("[^/]")
+			case '[':
+				end := strings.IndexByte(element[j+1:], ']')
+				if end < 0 {
+					expr.WriteString(`\[`)
+					continue
+				}
+				class := element[j+1 : j+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+				j += end + 1
+			case '\\':
+				if j+1 < len(element) {
+					j++
+				}
+				expr.WriteString(regexp.QuoteMeta(element[j : j+1]))
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		if !last {
+			expr.WriteByte('/')
+		}
+	}
+	return expr.String()
+}
+
+// matches reports whether the pattern matches the passed slash separated
+// path, relative to the pattern's base
+func (g *globPattern) matches(path string, isDir bool) bool {
+	if g.dirOnly && !isDir {
+		return false
+	}
+	return g.re.MatchString(path)
+}
+
+// ignoreRules are the patterns read from the ignore files of one directory
+type ignoreRules struct {
+	dir      string
+	patterns []*globPattern
+}
+
+// readIgnoreRules reads the patterns of the ignore files in dir. Lines that
+// are blank, comments or invalid patterns are skipped, as git does.
+func readIgnoreRules(dir string) ignoreRules {
+	rules := ignoreRules{dir: dir}
+	for _, name := range ignoreFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if !strings.HasSuffix(line, `\ `) {
+				line = strings.TrimRight(line, " ")
+			}
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if g, err := compileGlob(line); err == nil {
+				rules.patterns = append(rules.patterns, g)
+			}
+		}
+	}
+	return rules
+}
+
+// ignored reports whether the last of the passed rules to match path says
+// it is ignored. Rules must be ordered from the outermost directory in.
+func ignored(rules []ignoreRules, path string, isDir bool) bool {
+	result := false
+	for _, r := range rules {
+		rel, err := filepath.Rel(r.dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, g := range r.patterns {
+			if g.matches(rel, isDir) {
+				result = !g.negate
+			}
+		}
+	}
+	return result
+}
+
+// compileGlobs compiles each of the passed patterns, as used by the Include
+// and Exclude flags
+func compileGlobs(patterns []string) ([]*globPattern, error) {
+	globs := []*globPattern{}
+	for _, pattern := range patterns {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// matchesAny reports whether any of globs matches path, minding negated
+// patterns the way an ignore file does
+func matchesAny(globs []*globPattern, path string, isDir bool) bool {
+	return ignored([]ignoreRules{{dir: ".", patterns: globs}}, path, isDir)
+}
@@ -0,0 +1,154 @@
+package invertedindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests for include and exclude patterns and for respecting ignore files
+
+var ignorepath string = "test_files/ignore_files"
+
+func TestGlobPatterns(t *testing.T) {
+	assertGlobMatches(t, "*.log", "debug.log", false, true)
+	assertGlobMatches(t, "*.log", "logs/debug.log", false, true)
+	assertGlobMatches(t, "*.log", "debug.logs", false, false)
+	assertGlobMatches(t, "/*.log", "logs/debug.log", false, false)
+	assertGlobMatches(t, "docs/*.md", "docs/a.md", false, true)
+	assertGlobMatches(t, "docs/*.md", "docs/x/a.md", false, false)
+	assertGlobMatches(t, "docs/*.md", "src/docs/a.md", false, false)
+	assertGlobMatches(t, "docs/**/*.md", "docs/x/y/a.md", false, true)
+	assertGlobMatches(t, "docs/**/*.md", "docs/a.md", false, true)
+	assertGlobMatches(t, "**/build", "a/b/build", true, true)
+	assertGlobMatches(t, "vendor/**", "vendor/a/b.go", false, true)
+	assertGlobMatches(t, "file?.txt", "file1.txt", false, true)
+	assertGlobMatches(t, "file?.txt", "file10.txt", false, false)
+	assertGlobMatches(t, "file[0-9].txt", "file7.txt", false, true)
+	assertGlobMatches(t, "file[!0-9].txt", "file7.txt", false, false)
+	assertGlobMatches(t, "build/", "build", true, true)
+	assertGlobMatches(t, "build/", "build", false, false)
+	assertGlobMatches(t, `\#notes`, "#notes", false, true)
+	assertGlobMatches(t, "a+b.txt", "a+b.txt", false, true)
+}
+
+func TestInvalidGlobPattern(t *testing.T) {
+	if _, err := compileGlob("/"); err == nil {
+		t.Error("Expected an error compiling an empty pattern")
+	}
+}
+
+func TestIgnoredLastMatchWins(t *testing.T) {
+	rules := []ignoreRules{
+		{dir: "root", patterns: mustCompileGlobs(t, "*.log", "!keep.log")},
+		{dir: "root/sub", patterns: mustCompileGlobs(t, "keep.log")},
+	}
+	assertIgnored(t, rules, "root/debug.log", true)
+	assertIgnored(t, rules, "root/keep.log", false)
+	assertIgnored(t, rules, "root/sub/keep.log", true)
+	assertIgnored(t, rules, "root/a.txt", false)
+	assertIgnored(t, rules, "other/debug.log", false)
+}
+
+func TestCrawlRespectingIgnoreFiles(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true, RespectIgnore: true}, ignorepath)
+	expected := map[int]string{
+		0: filepath.Join(ignorepath, ".ignore"),
+		1: filepath.Join(ignorepath, "a.txt"),
+		2: filepath.Join(ignorepath, "b.md"),
+		3: filepath.Join(ignorepath, "docs/.ignore"),
+		4: filepath.Join(ignorepath, "docs/c.txt"),
+		5: filepath.Join(ignorepath, "keep.log"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+	assertSearchResult(t, indexer, "keep", []int{5})
+	assertSearchResult(t, indexer, "output", []int{})
+}
+
+func TestCrawlIgnoringIgnoreFiles(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true}, ignorepath)
+	if len(indexer.documents) != 9 {
+		t.Errorf("Expected number of documents indexed: 9, actual: %d", len(indexer.documents))
+	}
+}
+
+func TestCrawlGitignore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "invertedindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "*.tmp\n")
+	writeTestFile(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/master\n")
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "kept\n")
+	writeTestFile(t, filepath.Join(dir, "b.tmp"), "ignored\n")
+
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true, RespectIgnore: true}, dir)
+	expected := map[int]string{
+		0: filepath.Join(dir, ".gitignore"),
+		1: filepath.Join(dir, "a.txt"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestCrawlIncludePatterns(t *testing.T) {
+	flags := IndexerFlags{Recursive: true, Include: []string{"*.md", "build/*"}}
+	indexer := setUpIndexer(t, flags, ignorepath)
+	expected := map[int]string{
+		0: filepath.Join(ignorepath, "b.md"),
+		1: filepath.Join(ignorepath, "build/out.txt"),
+		2: filepath.Join(ignorepath, "docs/d.md"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestCrawlExcludePatterns(t *testing.T) {
+	flags := IndexerFlags{Recursive: true, Exclude: []string{"docs/", ".*", "*.log"}}
+	indexer := setUpIndexer(t, flags, ignorepath)
+	expected := map[int]string{
+		0: filepath.Join(ignorepath, "a.txt"),
+		1: filepath.Join(ignorepath, "b.md"),
+		2: filepath.Join(ignorepath, "build/out.txt"),
+	}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestCrawlExcludeSingleFile(t *testing.T) {
+	flags := IndexerFlags{Exclude: []string{"*.txt"}}
+	indexer := setUpIndexer(t, flags, filepath.Join(ignorepath, "a.txt"))
+	assertEqualDocumentMapping(t, indexer.documents, map[int]string{})
+}
+
+func assertGlobMatches(t *testing.T, pattern, path string, isDir, expected bool) {
+	g, err := compileGlob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.matches(path, isDir) != expected {
+		t.Errorf("Expected %q matching %q to be %v", pattern, path, expected)
+	}
+}
+
+func assertIgnored(t *testing.T, rules []ignoreRules, path string, expected bool) {
+	if ignored(rules, path, false) != expected {
+		t.Errorf("Expected %s ignored to be %v", path, expected)
+	}
+}
+
+func mustCompileGlobs(t *testing.T, patterns ...string) []*globPattern {
+	globs, err := compileGlobs(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return globs
+}
+
+func writeTestFile(t *testing.T, path, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,19 +5,91 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 type Indexer struct {
 	flags     IndexerFlags
 	nextDocID int
 	documents map[int]string
-	index     map[string][]int
+	// postings of the body field, i.e. the contents of the documents
+	index map[string][]int
+	// field name -> postings of that field; fields[bodyField] shares index
+	fields map[string]*field
+	// docID -> stored field values of documents read from JSON Lines files
+	stored map[int]map[string]string
+	// docID -> external ID of documents read from JSON Lines files
+	externalIDs map[int]string
+	// the index on disk the index was opened from, if any. documents, stored
+	// and externalIDs then cache the records read from it so far, guarded by
+	// mu.
+	disk *diskIndex
+	mu   sync.Mutex
+	// counts the builds and openings of the index, so that results cached
+	// for one are never taken for those of another, and the LRU caches of
+	// decoded posting lists and of query results; see SetCacheSizes
+	generation   int
+	postingCache *lruCache
+	queryCache   *lruCache
+
+	// state of the crawl in progress
+	root             string
+	include, exclude []*globPattern
+	// rules of the ignore files of the directories being read, outermost first
+	ignores []ignoreRules
+	// directories being read, and the first path each file and directory was
+	// reached by, when following symlinks
+	crawling map[fileKey]bool
+	visited  map[fileKey]string
+	// symlinks met when following symlinks, left to follow until every
+	// real file and directory has been read
+	symlinks []pendingSymlink
+	report   CrawlReport
+	// paths of the runs written so far when building the index on disk
+	runs []string
 }
 
 type IndexerFlags struct {
 	Abort     bool
 	Recursive bool
 	Verbose   bool
+	// Archives makes .zip, .tar, .tar.gz and .tgz files be read as the
+	// files they contain rather than as documents of their own
+	Archives bool
+	// JSONLines, if set, makes every .jsonl or .ndjson file be read as one
+	// document per line, with fields taken from each line's JSON record
+	JSONLines *JSONLinesConfig
+	// Include, if not empty, limits the crawl to files matching one of these
+	// patterns, and Exclude skips the files and directories matching one of
+	// these. Both use the syntax of .gitignore files and are matched against
+	// paths relative to the path the index is built on.
+	Include []string
+	Exclude []string
+	// RespectIgnore skips the files and directories that the .gitignore and
+	// .ignore files of the directories being read say to, the way git does.
+	// The .git directory is skipped too.
+	RespectIgnore bool
+	// MaxFileSize, if positive, skips the files, and members of archives, of
+	// more than this many bytes
+	MaxFileSize int64
+	// FollowSymlinks makes the crawl descend into the directories symlinks
+	// point to, which are otherwise skipped; links to files are always read.
+	// Files and directories reachable through several paths are read once,
+	// preferably by a path without symlinks, and symlink cycles are broken.
+	FollowSymlinks bool
+	// IndexDir, if set, makes the index be built on disk in this directory.
+	// Whenever the postings held in memory take more than MemoryBudget bytes,
+	// DefaultMemoryBudget if not positive, they are written out as a sorted
+	// run, and once every file is read the runs are merged into the index.
+	// Every build adds a new commit to the directory, and a build failing or
+	// crashing partway leaves the earlier commits as they were. The index
+	// can later be read with OpenIndex.
+	IndexDir     string
+	MemoryBudget int64
+	// Shards, if greater than one, makes the crawl only index the files of
+	// shard number Shard out of Shards, files going to shards by a hash of
+	// their path. See ShardedIndex.
+	Shard, Shards int
 }
 
 // eventual use for testing aborts in code
@@ -26,24 +98,73 @@ type IndexerFlags struct {
 // }
 
 func (i *Indexer) BuildIndex(flags IndexerFlags, path string) {
+	i.flags = flags
 	fmt.Printf("building index on directory: %s\n", path)
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	i.flags = flags
-	i.index = make(map[string][]int)
+	// the files of an index built before may be about to be overwritten
+	i.Close()
+	i.invalidate()
 	i.documents = make(map[int]string)
+	i.fields = map[string]*field{
+		bodyField: newField(false),
+		pathField: newField(true),
+		nameField: newField(true),
+		extField:  newField(true),
+	}
+	i.index = i.fields[bodyField].postings
+	i.stored = make(map[int]map[string]string)
+	i.externalIDs = make(map[int]string)
+	if i.include, err = compileGlobs(flags.Include); err == nil {
+		i.exclude, err = compileGlobs(flags.Exclude)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	i.ignores = nil
+	i.report = CrawlReport{}
+	i.crawling = make(map[fileKey]bool)
+	i.visited = make(map[fileKey]string)
+	i.symlinks = nil
+	i.runs = nil
+	if flags.IndexDir != "" {
+		// whatever a build that crashed left behind goes first
+		i.cleanup()
+		if err := os.MkdirAll(filepath.Join(flags.IndexDir, runsDir), 0755); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	if fileInfo.IsDir() {
+		i.root = path
 		i.readDirectory(fileInfo, path)
+		i.followSymlinks()
 	} else {
-		i.readFile(fileInfo, filepath.Dir(path))
+		i.root = filepath.Dir(path)
+		if !i.skipPath(path, false) && i.firstVisit(fileInfo, path) {
+			i.readFile(fileInfo, filepath.Dir(path))
+		}
+	}
+	if flags.IndexDir != "" {
+		i.writeIndexToFile()
 	}
 }
 
 func (i *Indexer) readDirectory(fileInfo os.FileInfo, path string) {
+	if !i.firstVisit(fileInfo, path) {
+		return
+	}
+	if i.flags.FollowSymlinks {
+		if key, ok := fileKeyOf(fileInfo, path); ok {
+			i.crawling[key] = true
+			defer delete(i.crawling, key)
+		}
+	}
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
 		if i.flags.Abort {
@@ -51,22 +172,79 @@ func (i *Indexer) readDirectory(fileInfo os.FileInfo, path string) {
 			i.cleanup()
 			os.Exit(1)
 		} else {
+			i.skipFile(path, err.Error())
 			return
 		}
 	}
+	if i.flags.RespectIgnore {
+		i.ignores = append(i.ignores, readIgnoreRules(path))
+		defer func() { i.ignores = i.ignores[:len(i.ignores)-1] }()
+	}
 	for _, subFileInfo := range files {
+		subPath := filepath.Join(path, subFileInfo.Name())
+		if subFileInfo.Mode()&os.ModeSymlink != 0 {
+			if i.flags.FollowSymlinks {
+				i.deferSymlink(subPath)
+				continue
+			}
+			// a link to a file is read like the file, but a linked directory
+			// is not descended into
+			subFileInfo, err = os.Stat(subPath)
+			if err != nil {
+				if i.flags.Abort {
+					fmt.Println(err)
+					i.cleanup()
+					os.Exit(1)
+				} else {
+					i.skipFile(subPath, err.Error())
+					continue
+				}
+			}
+			if subFileInfo.IsDir() {
+				i.skipFile(subPath, reasonSymlink)
+				continue
+			}
+		}
+		if i.skipPath(subPath, subFileInfo.IsDir()) {
+			continue
+		}
 		if subFileInfo.IsDir() {
 			if i.flags.Recursive {
-				i.readDirectory(subFileInfo, filepath.Join(path, subFileInfo.Name()))
+				i.readDirectory(subFileInfo, subPath)
 			}
-		} else {
+		} else if i.firstVisit(subFileInfo, subPath) {
 			i.readFile(subFileInfo, path)
 		}
 	}
 }
 
+// skipPath reports whether the crawl should skip the file or directory at
+// path, because of the Include, Exclude or RespectIgnore flags
+func (i *Indexer) skipPath(path string, isDir bool) bool {
+	rel, err := filepath.Rel(i.root, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+	if matchesAny(i.exclude, rel, isDir) {
+		return true
+	}
+	if i.flags.RespectIgnore {
+		if isDir && filepath.Base(path) == ".git" || ignored(i.ignores, path, isDir) {
+			return true
+		}
+	}
+	return !isDir && len(i.include) > 0 && !matchesAny(i.include, rel, false)
+}
+
 func (i *Indexer) readFile(fileInfo os.FileInfo, dir string) {
+	if !i.inShard(filepath.Join(dir, fileInfo.Name())) {
+		return
+	}
 	fmt.Printf("Reading file: %s\n", fileInfo.Name())
+	if i.tooLarge(filepath.Join(dir, fileInfo.Name()), fileInfo.Size()) {
+		return
+	}
 	contents, err := ioutil.ReadFile(filepath.Join(dir, fileInfo.Name()))
 	if err != nil {
 		if i.flags.Abort {
@@ -74,31 +252,65 @@ func (i *Indexer) readFile(fileInfo os.FileInfo, dir string) {
 			i.cleanup()
 			os.Exit(1)
 		} else {
+			i.skipFile(filepath.Join(dir, fileInfo.Name()), err.Error())
 			return
 		}
 	}
-	terms := ExtractTerms(contents)
+	i.indexFile(filepath.Join(dir, fileInfo.Name()), contents)
+	// fmt.Printf("File %s contains: %s\n", fileInfo.Name(), contents)
+}
+
+// indexFile indexes the contents of the file at path, which are either a
+// single document, or when enabled by the flags an archive of files or a
+// JSON Lines file of records. Text is transcoded to UTF-8 first, and files
+// that are binary or cannot be decoded are skipped.
+func (i *Indexer) indexFile(path string, contents []byte) {
+	if i.flags.Archives && isArchive(path) {
+		i.readArchive(path, contents)
+		return
+	}
+	contents, err := decodeText(contents)
+	if err != nil {
+		i.skipFile(path, err.Error())
+		return
+	}
+	if i.flags.JSONLines != nil && isJSONLinesFile(path) {
+		i.readJSONLines(path, contents)
+		return
+	}
 	docID := i.getNextDocID()
-	i.documents[docID] = filepath.Join(dir, fileInfo.Name())
-	for _, term := range terms {
-		termStr := string(term)
-		_, ok := i.index[termStr]
-		if !ok {
-			i.index[termStr] = []int{}
-		}
-		found := false
-		for _, id := range i.index[termStr] {
-			if id == docID {
-				found = true
-				break
-			}
-		}
-		if !found {
-			// fmt.Printf("adding term: %s id: %d pair to index\n", termStr, docID)
-			i.index[termStr] = append(i.index[termStr], docID)
+	i.documents[docID] = path
+	for name, text := range extractorFor(path, contents)(contents) {
+		i.indexText(name, text, docID)
+	}
+	i.indexPath(path, docID)
+	i.flushIfOverBudget()
+}
+
+// indexText tokenizes text and adds its terms, along with where they occur,
+// to the named field of the document with the passed docID. The field is
+// created if this is the first document to have it.
+func (i *Indexer) indexText(name string, text []byte, docID int) {
+	f, ok := i.fields[name]
+	if !ok {
+		f = newField(false)
+		i.fields[name] = f
+	}
+	for _, token := range ExtractTokens(text) {
+		// fmt.Printf("adding term: %s id: %d pair to index\n", token.Term, docID)
+		f.addOccurrence(string(token.Term), docID, occurrence{position: token.Position,
+			offset: token.Offset, length: len(token.Term), line: token.Line, column: token.Column})
+	}
+}
+
+// indexPath adds the terms of the path, name and ext fields of the file at
+// path to the document with the passed docID
+func (i *Indexer) indexPath(path string, docID int) {
+	for name, terms := range pathFieldTerms(path) {
+		for _, term := range terms {
+			i.fields[name].add(term, docID)
 		}
 	}
-	// fmt.Printf("File %s contains: %s\n", fileInfo.Name(), contents)
 }
 
 func (i *Indexer) getNextDocID() int {
@@ -107,11 +319,109 @@ func (i *Indexer) getNextDocID() int {
 	return temp
 }
 
-func (i *Indexer) writeIndexToFile() {
+// Path returns the path of the document with the passed docID, and whether
+// such a document was indexed
+func (i *Indexer) Path(docID int) (string, bool) {
+	record, ok := i.record(docID)
+	return record.Path, ok
+}
 
+// record returns the path, external ID and stored values of the document
+// with the passed docID, and whether such a document was indexed. The
+// record of a document of an index opened from disk is read on first use.
+func (i *Indexer) record(docID int) (docRecord, bool) {
+	if i.disk != nil {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		if _, ok := i.documents[docID]; !ok {
+			record, ok, err := i.disk.document(docID)
+			if !ok || err != nil {
+				return docRecord{}, false
+			}
+			i.documents[docID] = record.Path
+			if record.ExternalID != nil {
+				i.externalIDs[docID] = *record.ExternalID
+			}
+			if record.Stored != nil {
+				i.stored[docID] = record.Stored
+			}
+		}
+	}
+	path, ok := i.documents[docID]
+	if !ok {
+		return docRecord{}, false
+	}
+	record := docRecord{ID: docID, Path: path, Stored: i.stored[docID]}
+	if id, ok := i.externalIDs[docID]; ok {
+		record.ExternalID = &id
+	}
+	return record, true
 }
 
-// TODO: implement after filewriting;
-func (i *Indexer) cleanup() {
+// documentCount returns the number of documents indexed
+func (i *Indexer) documentCount() int {
+	if i.disk != nil {
+		return i.disk.meta.Documents
+	}
+	return len(i.documents)
+}
 
+// loadAll reads every document and posting of an index opened from disk
+// into memory, for the uses that walk them all
+func (i *Indexer) loadAll() {
+	if i.disk == nil {
+		return
+	}
+	for docID := 0; docID < i.nextDocID; docID++ {
+		i.record(docID)
+	}
+	for _, f := range i.fields {
+		f.loadAll()
+	}
+}
+
+// writeIndexToFile finishes building the index on disk: the postings still
+// held in memory are written as a last run, the runs are merged into a new
+// commit of the index and the index is opened from it, read in place like
+// one opened with OpenIndex rather than decoded back into memory
+func (i *Indexer) writeIndexToFile() {
+	dir := i.flags.IndexDir
+	if i.flags.Verbose {
+		fmt.Printf("Writing index to: %s\n", dir)
+	}
+	if i.memoryUsed() > 0 || len(i.runs) == 0 {
+		i.flushRun()
+	}
+	c, err := newCommitWriter(dir)
+	if err == nil {
+		if err = mergeRuns(i.runs, c); err == nil {
+			err = i.writeDocs(c)
+		}
+		if err == nil {
+			err = c.finish()
+		}
+		if err != nil {
+			c.abort()
+		}
+	}
+	if err == nil {
+		i.cleanup()
+		err = i.openIndex(dir, false)
+	}
+	if err != nil {
+		fmt.Println(err)
+		i.cleanup()
+		os.Exit(1)
+	}
+}
+
+// cleanup removes what building the index on disk leaves behind besides its
+// commits: the runs, the files of commits that never completed, whether
+// through an error or a crash, and the commits older than those kept
+func (i *Indexer) cleanup() {
+	if i.flags.IndexDir != "" {
+		os.RemoveAll(filepath.Join(i.flags.IndexDir, runsDir))
+		i.runs = nil
+		removeStaleCommits(i.flags.IndexDir)
+	}
 }
@@ -0,0 +1,385 @@
+package invertedindex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Files of a commit of an index written to disk. The terms file is the
+// dictionary of every field, sorted by field and then term, giving where the
+// postings of each term lie in the postings file. The docs file holds a JSON
+// record per document, and the meta file describes the index as a whole.
+// The two .idx files give the offset of every term entry and of every
+// document's record, as little endian uint64s, so that both can be found
+// without reading their files through; a document offset is one more than
+// that of its record, and zero for docIDs without a document.
+const (
+	termsFile     = "terms"
+	termIndexFile = "terms.idx"
+	postingsFile  = "postings"
+	docsFile      = "docs"
+	docIndexFile  = "docs.idx"
+	metaFile      = "meta.json"
+)
+
+// magic numbers starting the binary files, which name their format version
+const (
+	termsMagic     = "IIXTRM01"
+	termIndexMagic = "IIXTIX01"
+	postingsMagic  = "IIXPST01"
+	docIndexMagic  = "IIXDIX01"
+	runMagic       = "IIXRUN01"
+)
+
+var errCorrupt = errors.New("corrupt index file")
+
+// errUnsorted is met decoding postings whose docIDs are not strictly
+// increasing, which intersecting posting lists relies on
+var errUnsorted = errors.New("docIDs not strictly increasing")
+
+// indexMeta is the contents of the meta file
+type indexMeta struct {
+	Documents int                  `json:"documents"`
+	NextDocID int                  `json:"nextDocID"`
+	Fields    map[string]fieldMeta `json:"fields"`
+}
+
+type fieldMeta struct {
+	FoldCase bool `json:"foldCase"`
+}
+
+// docRecord is the record of a document in the docs file
+type docRecord struct {
+	ID         int               `json:"id"`
+	Path       string            `json:"path"`
+	ExternalID *string           `json:"externalId,omitempty"`
+	Stored     map[string]string `json:"stored,omitempty"`
+}
+
+// termEntry is an entry of the terms file
+type termEntry struct {
+	field, term string
+	// where the postings lie in the postings file
+	offset, length uint64
+	// number of documents the term occurs in
+	documentFrequency uint64
+}
+
+// encodePostings appends the encoding of a posting list to buf. The docIDs
+// must be sorted; occurrences may be nil for fields indexed without
+// positions. The encoding is a sequence of unsigned varints:
+//
+//	number of documents
+//	for each document:
+//	    docID - previous docID
+//	    number of occurrences
+//	    for each occurrence, each relative to the previous occurrence:
+//	        position delta, offset delta, length, line delta, column
+func encodePostings(buf []byte, docIDs []int, occurrences map[int][]occurrence) []byte {
+	buf = appendUvarint(buf, uint64(len(docIDs)))
+	previous := 0
+	for _, docID := range docIDs {
+		buf = appendUvarint(buf, uint64(docID-previous))
+		previous = docID
+		list := occurrences[docID]
+		buf = appendUvarint(buf, uint64(len(list)))
+		var last occurrence
+		for _, o := range list {
+			buf = appendUvarint(buf, uint64(o.position-last.position))
+			buf = appendUvarint(buf, uint64(o.offset-last.offset))
+			buf = appendUvarint(buf, uint64(o.length))
+			buf = appendUvarint(buf, uint64(o.line-last.line))
+			buf = appendUvarint(buf, uint64(o.column))
+			last = o
+		}
+	}
+	return buf
+}
+
+// decodePostings decodes a posting list encoded by encodePostings.
+// occurrences is nil if no document has any.
+func decodePostings(data []byte) (docIDs []int, occurrences map[int][]occurrence, err error) {
+	d := decoder{data: data}
+	n := d.uvarint()
+	if n > uint64(len(data)) {
+		return nil, nil, errCorrupt
+	}
+	docIDs = make([]int, 0, n)
+	docID := 0
+	for k := uint64(0); k < n && d.err == nil; k++ {
+		delta := d.uvarint()
+		if k > 0 && delta == 0 {
+			return nil, nil, errUnsorted
+		}
+		docID += int(delta)
+		docIDs = append(docIDs, docID)
+		count := d.uvarint()
+		if count == 0 {
+			continue
+		}
+		if count > uint64(len(data)) {
+			return nil, nil, errCorrupt
+		}
+		if occurrences == nil {
+			occurrences = make(map[int][]occurrence)
+		}
+		list := make([]occurrence, count)
+		var last occurrence
+		for j := range list {
+			o := occurrence{
+				position: last.position + int(d.uvarint()),
+				offset:   last.offset + int(d.uvarint()),
+				length:   int(d.uvarint()),
+				line:     last.line + int(d.uvarint()),
+				column:   int(d.uvarint()),
+			}
+			list[j], last = o, o
+		}
+		occurrences[docID] = list
+	}
+	if d.err == nil && len(d.data) != 0 {
+		d.err = errCorrupt
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return docIDs, occurrences, nil
+}
+
+// decoder reads unsigned varints from a byte slice, remembering the first
+// error met so that it only needs checking once
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// string reads a string preceded by its length, as written by writeString
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errCorrupt
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buf, scratch[:binary.PutUvarint(scratch[:], v)]...)
+}
+
+// writeString writes s preceded by its length
+func writeString(w *bufio.Writer, s string) error {
+	if _, err := w.Write(appendUvarint(nil, uint64(len(s)))); err != nil {
+		return err
+	}
+	_, err := w.WriteString(s)
+	return err
+}
+
+// readString reads a string written by writeString
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(buf), nil
+}
+
+// unexpectedEOF turns an EOF met in the middle of a record into an error
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendTermEntry appends the encoding of a term entry to buf: the field and
+// the term preceded by their lengths, then the offset, length and document
+// frequency as varints
+func appendTermEntry(buf []byte, e termEntry) []byte {
+	buf = appendUvarint(buf, uint64(len(e.field)))
+	buf = append(buf, e.field...)
+	buf = appendUvarint(buf, uint64(len(e.term)))
+	buf = append(buf, e.term...)
+	buf = appendUvarint(buf, e.offset)
+	buf = appendUvarint(buf, e.length)
+	return appendUvarint(buf, e.documentFrequency)
+}
+
+// parseTermEntry decodes the term entry data starts with, returning its
+// length in bytes too
+func parseTermEntry(data []byte) (termEntry, int, error) {
+	d := decoder{data: data}
+	e := termEntry{field: d.string(), term: d.string()}
+	e.offset = d.uvarint()
+	e.length = d.uvarint()
+	e.documentFrequency = d.uvarint()
+	return e, len(data) - len(d.data), d.err
+}
+
+// readMagic checks that r starts with the passed magic number
+func readMagic(r *bufio.Reader, magic string) error {
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != magic {
+		return fmt.Errorf("not an index file of version %s", magic)
+	}
+	return nil
+}
+
+// writeDocs writes the docs, docs.idx and meta files of the index to a
+// commit
+func (i *Indexer) writeDocs(c *commitWriter) error {
+	w, err := c.create(docsFile)
+	if err != nil {
+		return err
+	}
+	iw, err := c.create(docIndexFile)
+	if err != nil {
+		return err
+	}
+	if _, err := iw.WriteString(docIndexMagic); err != nil {
+		return err
+	}
+	var offset uint64
+	var scratch [8]byte
+	for docID := 0; docID < i.nextDocID; docID++ {
+		record, ok := i.record(docID)
+		binary.LittleEndian.PutUint64(scratch[:], 0)
+		if ok {
+			line, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			line = append(line, '\n')
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint64(scratch[:], offset+1)
+			offset += uint64(len(line))
+		}
+		if _, err := iw.Write(scratch[:]); err != nil {
+			return err
+		}
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := iw.close(); err != nil {
+		return err
+	}
+
+	meta := indexMeta{Documents: len(i.documents), NextDocID: i.nextDocID,
+		Fields: make(map[string]fieldMeta)}
+	for name, f := range i.fields {
+		meta.Fields[name] = fieldMeta{FoldCase: f.foldCase}
+	}
+	contents, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := c.create(metaFile)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(contents); err != nil {
+		return err
+	}
+	return mw.close()
+}
+
+// OpenIndex opens the index written to dir by building one with the IndexDir
+// flag. The files of the index are mapped into memory rather than read, and
+// postings and documents are only decoded once used. The index should be
+// closed once done with.
+//
+// Every block of the latest commit is first checked against its checksum,
+// falling back to the previous commits if it is corrupt, so opening reads
+// the whole index once. Should the files change on disk afterwards, a search
+// reading a corrupt block fails, as does every later search of the index.
+func OpenIndex(dir string) (*Indexer, error) {
+	i := new(Indexer)
+	if err := i.openIndex(dir, true); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// openIndex replaces the contents of the index with the index written to
+// dir, read in place, checking every block first if verify is set
+func (i *Indexer) openIndex(dir string, verify bool) error {
+	disk, err := openDiskIndex(dir, verify)
+	if err != nil {
+		return err
+	}
+	i.disk = disk
+	i.invalidate()
+	i.nextDocID = disk.meta.NextDocID
+	i.documents = make(map[int]string)
+	i.stored = make(map[int]map[string]string)
+	i.externalIDs = make(map[int]string)
+	i.fields = make(map[string]*field)
+	for name, m := range disk.meta.Fields {
+		f := newField(m.FoldCase)
+		f.disk = disk
+		f.start, f.end = disk.fieldRange(name)
+		f.cache = i.postingCache
+		i.fields[name] = f
+	}
+	if i.fields[bodyField] == nil {
+		i.fields[bodyField] = newField(false)
+	}
+	if err := disk.failure(); err != nil {
+		disk.close()
+		i.disk = nil
+		return err
+	}
+	i.index = i.fields[bodyField].postings
+	return nil
+}
+
+// diskError returns the error, if any, reading the files of an index opened
+// from disk met
+func (i *Indexer) diskError() error {
+	if i.disk == nil {
+		return nil
+	}
+	return i.disk.failure()
+}
+
+// Close releases the files of an index opened with OpenIndex, or built with
+// the IndexDir flag. The index must not be used once closed.
+func (i *Indexer) Close() error {
+	if i.disk == nil {
+		return nil
+	}
+	err := i.disk.close()
+	i.disk = nil
+	return err
+}
@@ -0,0 +1,152 @@
+package invertedindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JSONLinesConfig says how the records of a JSON Lines file become
+// documents. For example, exports whose lines look like
+//
+//	{"id": "a1", "title": "...", "body": "...", "tags": ["x", "y"]}
+//
+// could be read with
+//
+//	{"id": "id", "indexed": ["title", "body", "tags"], "stored": ["title"]}
+type JSONLinesConfig struct {
+	// ID is the key holding the external ID of a record. Records without one
+	// are identified by their line number instead.
+	ID string `json:"id"`
+	// Indexed are the keys whose values are indexed. Each is indexed as the
+	// field of the same name, so the value of "body" is searched by default
+	// and the others are searched by scoping a query, e.g. title:go
+	Indexed []string `json:"indexed"`
+	// Stored are the keys whose values are kept with the document so that
+	// they can be shown with results
+	Stored []string `json:"stored"`
+}
+
+// LoadJSONLinesConfig reads a JSONLinesConfig from the JSON file at path
+func LoadJSONLinesConfig(path string) (*JSONLinesConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := new(JSONLinesConfig)
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(config.Indexed) == 0 {
+		return nil, fmt.Errorf("%s: no indexed keys", path)
+	}
+	for _, key := range config.Indexed {
+		if key == pathField || key == nameField || key == extField {
+			return nil, fmt.Errorf("%s: indexed key %q clashes with the field of the same name "+
+				"every file is indexed under", path, key)
+		}
+	}
+	return config, nil
+}
+
+// isJSONLinesFile reports whether the file at path is named like a JSON
+// Lines file
+func isJSONLinesFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jsonl" || ext == ".ndjson"
+}
+
+// readJSONLines indexes every non blank line of the JSON Lines file at path
+// as its own document, as described by the JSONLines flag. A document's path
+// is that of the file followed by "#" and its external ID. Lines that are not
+// JSON are recorded as skipped under the path and line number.
+func (i *Indexer) readJSONLines(path string, contents []byte) {
+	config := i.flags.JSONLines
+	for n, line := range bytes.Split(contents, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var record map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			if i.flags.Abort {
+				fmt.Printf("%s:%d: %s\n", path, n+1, err)
+				i.cleanup()
+				os.Exit(1)
+			} else {
+				i.skipFile(fmt.Sprintf("%s:%d", path, n+1), err.Error())
+				continue
+			}
+		}
+		id := strconv.Itoa(n + 1)
+		if value, ok := record[config.ID]; ok && config.ID != "" {
+			id = jsonText(value)
+		}
+		docID := i.getNextDocID()
+		i.documents[docID] = path + "#" + id
+		i.externalIDs[docID] = id
+		for _, key := range config.Indexed {
+			if value, ok := record[key]; ok {
+				i.indexText(key, []byte(jsonText(value)), docID)
+			}
+		}
+		i.indexPath(path, docID)
+		if len(config.Stored) > 0 {
+			stored := make(map[string]string)
+			for _, key := range config.Stored {
+				if value, ok := record[key]; ok {
+					stored[key] = jsonText(value)
+				}
+			}
+			i.stored[docID] = stored
+		}
+		i.flushIfOverBudget()
+	}
+}
+
+// jsonText returns the text a decoded JSON value is indexed and stored as.
+// The elements of an array are put on lines of their own, so that they are
+// separate tokens, and objects are kept as JSON.
+func jsonText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		elements := make([]string, len(v))
+		for k, element := range v {
+			elements[k] = jsonText(element)
+		}
+		return strings.Join(elements, "\n")
+	case map[string]interface{}:
+		text, _ := json.Marshal(v)
+		return string(text)
+	}
+	return fmt.Sprint(value)
+}
+
+// ExternalID returns the external ID of the document with the passed docID,
+// if it was read from a JSON Lines file
+func (i *Indexer) ExternalID(docID int) (string, bool) {
+	record, _ := i.record(docID)
+	if record.ExternalID == nil {
+		return "", false
+	}
+	return *record.ExternalID, true
+}
+
+// Stored returns the stored field values of the document with the passed
+// docID, keyed by their JSON key. Only documents read from JSON Lines files
+// have stored values.
+func (i *Indexer) Stored(docID int) map[string]string {
+	record, _ := i.record(docID)
+	return record.Stored
+}
@@ -0,0 +1,90 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for reading JSON Lines files as one document per record. The valid
+// records of test_files/jsonl_files/records.jsonl get docIDs 0 through 3
+
+var jsonlpath string = "test_files/jsonl_files"
+
+func setUpJSONLinesIndexer(t *testing.T) *Indexer {
+	config, err := LoadJSONLinesConfig(filepath.Join(jsonlpath, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return setUpIndexer(t, IndexerFlags{JSONLines: config}, filepath.Join(jsonlpath, "records.jsonl"))
+}
+
+func TestJSONLinesDocuments(t *testing.T) {
+	indexer := setUpJSONLinesIndexer(t)
+	records := filepath.Join(jsonlpath, "records.jsonl")
+	expected := map[int]string{0: records + "#a1", 1: records + "#42", 2: records + "#4", 3: records + "#c3"}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestJSONLinesExternalIDs(t *testing.T) {
+	indexer := setUpJSONLinesIndexer(t)
+	for docID, expected := range map[int]string{0: "a1", 1: "42", 2: "4", 3: "c3"} {
+		if id, ok := indexer.ExternalID(docID); !ok || id != expected {
+			t.Errorf("Expected external ID of %d: %s, actual: %s", docID, expected, id)
+		}
+	}
+}
+
+func TestJSONLinesReportsMalformedLines(t *testing.T) {
+	report := setUpJSONLinesIndexer(t).Report()
+	line := filepath.Join(jsonlpath, "records.jsonl") + ":5"
+	if report.Indexed != 4 || len(report.Skipped) != 1 || report.Skipped[0].Path != line ||
+		report.Skipped[0].Reason == "" {
+		t.Errorf("Expected %s to be reported as skipped, actual: %+v", line, report)
+	}
+}
+
+func TestJSONLinesStoredFields(t *testing.T) {
+	indexer := setUpJSONLinesIndexer(t)
+	expected := map[string]string{"title": "Go modules", "body": "modules replace the gopath"}
+	if actual := indexer.Stored(1); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected stored fields: %v, actual: %v", expected, actual)
+	}
+	expected = map[string]string{"title": "Empty"}
+	if actual := indexer.Stored(3); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected stored fields: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestSearchJSONLinesFields(t *testing.T) {
+	indexer := setUpJSONLinesIndexer(t)
+	assertSearchResult(t, indexer, "installer", []int{0, 2})
+	assertSearchResult(t, indexer, "title:Go", []int{0, 1})
+	assertSearchResult(t, indexer, "tags:setup", []int{0, 2})
+	assertSearchResult(t, indexer, "tags:setup title:Go", []int{0})
+	assertSearchResult(t, indexer, "ignored", []int{})
+	assertSearchResult(t, indexer, "ext:jsonl", []int{0, 1, 2, 3})
+}
+
+func TestJSONLinesSnippetsOfStoredBody(t *testing.T) {
+	indexer := setUpJSONLinesIndexer(t)
+	snippets, err := indexer.Snippets("rustup title:Rust", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{"run the [rustup] installer"})
+}
+
+func TestJSONLinesFilesReadWholeWithoutConfig(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(jsonlpath, "records.jsonl"))
+	expected := map[int]string{0: filepath.Join(jsonlpath, "records.jsonl")}
+	assertEqualDocumentMapping(t, indexer.documents, expected)
+}
+
+func TestLoadJSONLinesConfigErrors(t *testing.T) {
+	for _, path := range []string{"missing.json", "records.jsonl"} {
+		if _, err := LoadJSONLinesConfig(filepath.Join(jsonlpath, path)); err == nil {
+			t.Errorf("expected error loading config %s", path)
+		}
+	}
+}
@@ -7,31 +7,121 @@ files in the directory into memory.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/killeent/invertedindex"
-	"io/ioutil"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 )
 
+// commands that can be given before the flags; without one the index is
+// built and queried once
+var commands = map[string]bool{"serve": true, "stats": true, "dump": true, "verify": true, "eval": true}
+
 func main() {
 	// Components to be passed to our indexer
-	var indexDir string
-	var abort, recursive bool
+	var indexDir, query, jsonLines, include, exclude, addr string
+	var abort, recursive, archives, respectIgnore, symlinks, verbose, snippets, grep, report bool
+	var asJSON, repair, rank, explain bool
+	var shards int
+	var maxSize int64
+	var top, doc int
+	var term, prefix, fieldName, topics, qrels string
+	var cutoff int
+	var outDir, openDir string
+	var memory int64
 
 	flag.BoolVar(&abort, "a", false, "If a file or directory cannot be read during indexing"+
 		"terminate immediately")
 	flag.BoolVar(&recursive, "r", false, "Index the directory contents recursively")
-	// flag.BoolVar(&verbose, "v", false, "Log information about the indexing process to to the console")
-	// flag.Parse()
+	flag.BoolVar(&archives, "z", false, "Index the files inside .zip, .tar, .tar.gz and .tgz archives, "+
+		"named like bundle.zip!/docs/a.txt")
+	flag.StringVar(&include, "include", "", "Comma separated glob patterns; only files matching one "+
+		"of them are indexed, e.g. *.go,docs/**/*.md")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated glob patterns of files and directories "+
+		"to skip, e.g. vendor/,*_test.go")
+	flag.BoolVar(&respectIgnore, "ignore", false, "Skip the files and directories ignored by "+
+		".gitignore and .ignore files, and .git directories")
+	flag.BoolVar(&symlinks, "L", false, "Follow symlinks, reading each file and directory once "+
+		"however many paths lead to it")
+	flag.Int64Var(&maxSize, "maxsize", 0, "Skip files larger than this many bytes; 0 means no limit")
+	flag.BoolVar(&report, "report", false, "Print the files skipped while indexing and why")
+	flag.StringVar(&query, "q", "", "Query to run against the index once it is built; "+
+		"terms are combined with AND, term~N matches terms within edit distance N and "+
+		"/re/ matches terms against a regular expression, \"a b\" matches a phrase and "+
+		"a /k b matches a and b within k tokens of each other. Prefix any of these with "+
+		"name:, ext: or path: to search the file name, extension or path instead of the contents, "+
+		"or with title: or heading: to search the title or headings of HTML files")
+	flag.BoolVar(&snippets, "s", false, "Print snippets of each matching document with the matches marked")
+	flag.BoolVar(&grep, "g", false, "Print every matching line as path:line:column: text, like grep")
+	flag.StringVar(&jsonLines, "jsonl", "", "Path of a JSON file configuring how .jsonl files are "+
+		"read, one document per line; see invertedindex.JSONLinesConfig")
+	flag.BoolVar(&verbose, "v", false, "Log information about the indexing process to to the console")
+	flag.StringVar(&outDir, "o", "", "Build the index on disk in this directory, so that it can "+
+		"be larger than memory and opened later with -open")
+	flag.Int64Var(&memory, "mem", invertedindex.DefaultMemoryBudget>>20, "Megabytes of postings "+
+		"held in memory before they are written out as a run, when building the index with -o")
+	flag.StringVar(&openDir, "open", "", "Open the index previously built in this directory "+
+		"rather than building one. Several comma separated directories are searched as one, "+
+		"each hit labeled with its directory; only searching with -q is supported then")
+	flag.IntVar(&shards, "shards", 1, "Split the documents across this many shards, built and "+
+		"searched in parallel; with -o each shard is built in a directory of its own, and with "+
+		"-open every shard in the directory is opened. Only searching with -q is supported")
+	flag.BoolVar(&rank, "rank", false, "Order the documents matching -q by tf-idf score, printing "+
+		"their scores")
+	flag.BoolVar(&explain, "explain", false, "Order the documents matching -q by score like -rank, "+
+		"and print how each score is made up of the scores of the query's clauses and terms")
+	flag.StringVar(&addr, "addr", "localhost:8080", "Address the serve command listens on")
+	flag.BoolVar(&asJSON, "json", false, "Print the output of the stats, dump, verify and eval "+
+		"commands as JSON")
+	flag.StringVar(&term, "term", "", "Term whose postings the dump command prints")
+	flag.StringVar(&prefix, "prefix", "", "Prefix of the terms the dump command lists")
+	flag.IntVar(&doc, "doc", -1, "DocID of the document whose terms the dump command lists")
+	flag.StringVar(&fieldName, "field", "body", "Field the dump command looks up -term and -prefix in")
+	flag.BoolVar(&repair, "repair", false, "Have the verify command rebuild the structures of the "+
+		"index derived from the rest, if those are all that is wrong")
+	flag.IntVar(&top, "top", invertedindex.DefaultTopTerms, "Number of most frequent terms "+
+		"the stats command lists")
+	flag.StringVar(&topics, "topics", "", "File of the queries the eval command runs, a topic ID "+
+		"and a query per line, or TREC topics whose titles are the queries")
+	flag.StringVar(&qrels, "qrels", "", "TREC qrels file of the relevance judgments the eval "+
+		"command measures rankings against; documents are named by external ID or path")
+	flag.IntVar(&cutoff, "k", invertedindex.DefaultEvalCutoff, "Rank the eval command measures "+
+		"precision at")
+
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && commands[args[0]] {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
 
-	if len(flag.Args()) != 1 {
+	if command == "verify" {
+		if openDir == "" || len(flag.Args()) != 0 {
+			usage()
+			os.Exit(1)
+		}
+		verify(openDir, repair, asJSON)
+		return
+	}
+
+	if openDir != "" && len(flag.Args()) == 0 {
+		// the index is opened rather than built
+	} else if len(flag.Args()) != 1 {
 		usage()
 		os.Exit(1)
+	} else {
+		indexDir = flag.Args()[0]
+	}
+	if command == "stats" && top < 0 {
+		fmt.Printf("invalid -top: %d\n", top)
+		os.Exit(1)
 	}
-	indexDir = flag.Args()[0]
 
-	if recursive {
+	if recursive && verbose {
 		fmt.Println("Reading the directory recursively")
 	}
 
@@ -42,11 +132,453 @@ func main() {
 	// 	fmt.Println(err)
 	// 	os.Exit(1)
 	// }
-	indexer := new(Indexer)
-	flags := IndexerFlags{Abort: abort, Recursive: recursive}
-	indexer.BuildIndex(flags, indexDir)
+	flags := invertedindex.IndexerFlags{Abort: abort, Recursive: recursive, Archives: archives,
+		Verbose: verbose, Include: patterns(include), Exclude: patterns(exclude),
+		RespectIgnore: respectIgnore, MaxFileSize: maxSize,
+		FollowSymlinks: symlinks, IndexDir: outDir, MemoryBudget: memory << 20}
+	if jsonLines != "" {
+		config, err := invertedindex.LoadJSONLinesConfig(jsonLines)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		flags.JSONLines = config
+	}
+
+	if dirs := strings.Split(openDir, ","); len(dirs) > 1 {
+		if command != "" || grep {
+			fmt.Println("Only searching with -q is supported across several indexes")
+			os.Exit(1)
+		}
+		federated, err := invertedindex.OpenFederatedIndex(dirs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer federated.Close()
+		if query != "" {
+			searchFederated(federated, query, snippets, explain)
+		}
+		return
+	}
+
+	if shards > 1 {
+		if command != "" || grep {
+			fmt.Println("Only searching with -q is supported with -shards")
+			os.Exit(1)
+		}
+		var sharded *invertedindex.ShardedIndex
+		if openDir != "" {
+			var err error
+			if sharded, err = invertedindex.OpenShardedIndex(openDir); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		} else {
+			sharded = invertedindex.BuildShardedIndex(flags, indexDir, shards)
+			if report {
+				printReport(sharded.Report())
+			}
+		}
+		defer sharded.Close()
+		if query != "" {
+			search(sharded, query, snippets, rank, explain)
+		}
+		return
+	}
+
+	indexer := new(invertedindex.Indexer)
+	if openDir != "" {
+		var err error
+		if indexer, err = invertedindex.OpenIndex(openDir); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		indexer.BuildIndex(flags, indexDir)
+		if report {
+			printReport(indexer.Report())
+		}
+	}
+	defer indexer.Close()
+
+	if command == "serve" {
+		serve(indexer, addr)
+	} else if command == "stats" {
+		printStats(indexer.Stats(top), asJSON)
+	} else if command == "dump" {
+		dump(indexer, fieldName, term, prefix, doc, asJSON)
+	} else if command == "eval" {
+		evaluate(indexer, topics, qrels, cutoff, asJSON)
+	} else if query != "" && grep {
+		searchLines(indexer, query)
+	} else if query != "" {
+		search(indexer, query, snippets, rank, explain)
+	}
+}
+
+// printReport prints how many documents were indexed and every file that
+// was skipped, with the reason
+func printReport(report invertedindex.CrawlReport) {
+	fmt.Printf("%d documents indexed, %d files skipped\n", report.Indexed, len(report.Skipped))
+	for _, skipped := range report.Skipped {
+		fmt.Printf("    %s: %s\n", skipped.Path, skipped.Reason)
+	}
+}
+
+// patterns splits a comma separated list of glob patterns
+func patterns(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// searcher is what searching needs of an index, sharded or not
+type searcher interface {
+	Search(query string) ([]int, error)
+	RankedSearch(query string) ([]invertedindex.Hit, error)
+	Path(docID int) (string, bool)
+	Stored(docID int) map[string]string
+	Snippets(query string, docID int) ([]invertedindex.Snippet, error)
+	Explain(query string, docID int) (invertedindex.Explanation, error)
+}
+
+// search runs query against index and prints the path of every match,
+// followed by its snippets when requested. Ranked matches are printed
+// best first along with their scores, and the explanations of the scores
+// when requested.
+func search(index searcher, query string, snippets, rank, explain bool) {
+	var hits []invertedindex.Hit
+	var err error
+	rank = rank || explain
+	if rank {
+		hits, err = index.RankedSearch(query)
+	} else {
+		var docIDs []int
+		docIDs, err = index.Search(query)
+		for _, docID := range docIDs {
+			hits = append(hits, invertedindex.Hit{DocID: docID})
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d documents matched: %s\n", len(hits), query)
+	for _, hit := range hits {
+		path, _ := index.Path(hit.DocID)
+		if rank {
+			fmt.Printf("%.4f %s\n", hit.Score, path)
+		} else {
+			fmt.Println(path)
+		}
+		printStored(index.Stored(hit.DocID))
+		if explain {
+			printExplanation(index, query, hit.DocID)
+		}
+		if snippets {
+			printSnippets(index, query, hit.DocID)
+		}
+	}
+}
+
+// searchFederated runs query against every index of federated and prints
+// the matches best first, with their normalized scores and the directory of
+// their index. Explanations are of the scores within the index, before
+// normalization.
+func searchFederated(federated *invertedindex.FederatedIndex, query string, snippets, explain bool) {
+	hits, err := federated.Search(query)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d documents matched: %s\n", len(hits), query)
+	for _, hit := range hits {
+		fmt.Printf("%.4f [%s] %s\n", hit.Score, hit.Index, hit.Path)
+		index, _ := federated.Index(hit.Index)
+		printStored(index.Stored(hit.DocID))
+		if explain {
+			printExplanation(index, query, hit.DocID)
+		}
+		if snippets {
+			printSnippets(index, query, hit.DocID)
+		}
+	}
+}
+
+// printStored prints the stored field values of a matching document on one
+// indented line each, ordered by key
+func printStored(stored map[string]string) {
+	keys := make([]string, 0, len(stored))
+	for key := range stored {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("    %s: %s\n", key, strings.Join(strings.Fields(stored[key]), " "))
+	}
+}
+
+// printExplanation prints the explanation of the score of a matching
+// document, indented
+func printExplanation(index searcher, query string, docID int) {
+	explanation, err := index.Explain(query, docID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(explanation.String(), "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// printSnippets prints the snippets of a matching document on one indented
+// line each, with the matches surrounded by brackets
+func printSnippets(index searcher, query string, docID int) {
+	snippets, err := index.Snippets(query, docID)
+	if err != nil {
+		fmt.Printf("    %s\n", err)
+		return
+	}
+	for _, s := range snippets {
+		fmt.Printf("    %s\n", strings.Join(strings.Fields(s.Highlight("[", "]")), " "))
+	}
+}
+
+// searchLines runs query against indexer and prints every line it matched
+// in the style of grep
+func searchLines(indexer *invertedindex.Indexer, query string) {
+	docIDs, err := indexer.Search(query)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, docID := range docIDs {
+		path, _ := indexer.Path(docID)
+		hits, err := indexer.LineHits(query, docID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			continue
+		}
+		for _, hit := range hits {
+			fmt.Printf("%s:%d:%d: %s\n", path, hit.Line, hit.Column, hit.Text)
+		}
+	}
+}
+
+// serve answers search requests against indexer over HTTP until the
+// server fails
+func serve(indexer *invertedindex.Indexer, addr string) {
+	fmt.Printf("Serving /search, /doc and /stats on http://%s\n", addr)
+	if err := http.ListenAndServe(addr, invertedindex.NewServer(indexer)); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// printStats prints statistics of the index as a table, or as JSON
+func printStats(stats invertedindex.Stats, asJSON bool) {
+	if asJSON {
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "documents\t%d\n", stats.Documents)
+	fmt.Fprintf(w, "terms\t%d\n", stats.Terms)
+	fmt.Fprintf(w, "postings\t%d\n", stats.Postings)
+	fmt.Fprintf(w, "tokens\t%d\n", stats.Tokens)
+	if stats.Heaps != nil {
+		fmt.Fprintf(w, "heaps' law\tterms = %.3g * tokens^%.3f\t(r2 %.3f)\n",
+			stats.Heaps.Coefficient, stats.Heaps.Exponent, stats.Heaps.R2)
+	}
+	if stats.Zipf != nil {
+		fmt.Fprintf(w, "zipf's law\tfrequency = %.3g * rank^-%.3f\t(r2 %.3f)\n",
+			stats.Zipf.Coefficient, stats.Zipf.Exponent, stats.Zipf.R2)
+	}
+	fmt.Fprintln(w, "\nposting length\tterms")
+	for _, b := range stats.PostingLengths {
+		fmt.Fprintf(w, "%d-%d\t%d\n", b.Min, b.Max, b.Terms)
+	}
+	fmt.Fprintln(w, "\nterm\tdocuments\toccurrences")
+	for _, t := range stats.TopTerms {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", t.Term, t.DocumentFrequency, t.CollectionFrequency)
+	}
+	fmt.Fprintln(w, "\nfield\tterms\tpostings")
+	names := make([]string, 0, len(stats.Fields))
+	for name := range stats.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", name, stats.Fields[name].Terms, stats.Fields[name].Postings)
+	}
+	w.Flush()
+}
+
+// verify checks the index in dir, repairing it if asked to, and exits with
+// status 1 if problems remain
+func verify(dir string, repair, asJSON bool) {
+	var report invertedindex.VerifyReport
+	var err error
+	if repair {
+		report, err = invertedindex.RepairIndex(dir)
+	} else {
+		report, err = invertedindex.VerifyIndex(dir)
+	}
+	if asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("commit %d: %d documents, %d terms, %d postings\n", report.Generation,
+			report.Documents, report.Terms, report.Postings)
+		for _, p := range report.Problems {
+			kind := ""
+			if p.Derived {
+				kind = " (repairable)"
+			}
+			fmt.Printf("    %s: %s%s\n", p.File, p.Message, kind)
+		}
+		if report.More > 0 {
+			fmt.Printf("    and %d more problems\n", report.More)
+		}
+		if report.OK() && err == nil {
+			fmt.Println("ok")
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// dump prints the postings of term, the terms starting with prefix and the
+// terms of the document with docID doc, for each that was given
+func dump(indexer *invertedindex.Indexer, fieldName, term, prefix string, doc int, asJSON bool) {
+	if term == "" && prefix == "" && doc < 0 {
+		fmt.Println("dump needs at least one of -term, -prefix and -doc")
+		os.Exit(1)
+	}
+	output := make(map[string]interface{})
+	var err error
+	if term != "" {
+		if output["postings"], err = indexer.Postings(fieldName, term); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if prefix != "" {
+		if output["terms"], err = indexer.TermsWithPrefix(fieldName, prefix); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if doc >= 0 {
+		if output["document"], err = indexer.DocumentTerms(doc); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if asJSON {
+		out, _ := json.MarshalIndent(output, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if postings, ok := output["postings"].([]invertedindex.TermPosting); ok {
+		fmt.Fprintf(w, "postings of %s:%s\n", fieldName, term)
+		fmt.Fprintln(w, "docID\tfrequency\tpositions")
+		for _, p := range postings {
+			fmt.Fprintf(w, "%d\t%d\t%s\n", p.DocID, p.Frequency, strings.Trim(fmt.Sprint(p.Positions), "[]"))
+		}
+		fmt.Fprintln(w)
+	}
+	if terms, ok := output["terms"].([]invertedindex.TermStats); ok {
+		fmt.Fprintf(w, "terms of %s starting with %s\n", fieldName, prefix)
+		fmt.Fprintln(w, "term\tdocuments\toccurrences")
+		for _, t := range terms {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", t.Term, t.DocumentFrequency, t.CollectionFrequency)
+		}
+		fmt.Fprintln(w)
+	}
+	if terms, ok := output["document"].([]invertedindex.DocumentTerm); ok {
+		path, _ := indexer.Path(doc)
+		fmt.Fprintf(w, "terms of document %d, %s\n", doc, path)
+		fmt.Fprintln(w, "field\tterm\tfrequency")
+		for _, t := range terms {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", t.Field, t.Term, t.Frequency)
+		}
+	}
+	w.Flush()
+}
+
+// evaluate runs the queries of the topics file against the index and
+// prints how well the rankings match the qrels file, per query and on
+// average, as a table or as JSON
+func evaluate(indexer *invertedindex.Indexer, topicsPath, qrelsPath string, k int, asJSON bool) {
+	if topicsPath == "" || qrelsPath == "" || k <= 0 {
+		usage()
+		os.Exit(1)
+	}
+	f, err := os.Open(topicsPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	topics, err := invertedindex.ReadTopics(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if f, err = os.Open(qrelsPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	qrels, err := invertedindex.ReadQrels(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	evaluation := indexer.Evaluate(topics, qrels, k)
+	if asJSON {
+		out, _ := json.MarshalIndent(evaluation, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "topic\tP@%d\trecall\tAP\tRR\tnDCG\tretrieved\trelevant\tquery\n", k)
+	for _, q := range evaluation.Queries {
+		fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%d\t%d/%d\t%s\n", q.Topic, q.PrecisionAtK,
+			q.Recall, q.AveragePrecision, q.ReciprocalRank, q.NDCG, q.Retrieved,
+			q.RelevantRetrieved, q.Relevant, q.Query)
+		if q.Error != "" {
+			fmt.Fprintf(w, "\t%s\n", q.Error)
+		}
+	}
+	m := evaluation.Mean
+	fmt.Fprintf(w, "mean\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t\t\t%d queries\n", m.PrecisionAtK, m.Recall,
+		m.AveragePrecision, m.ReciprocalRank, m.NDCG, len(evaluation.Queries))
+	w.Flush()
+	fmt.Println("\nMAP is the mean AP and MRR the mean RR")
+	if len(evaluation.Unjudged) > 0 {
+		fmt.Printf("topics without relevant documents, left out: %s\n", strings.Join(evaluation.Unjudged, " "))
+	}
 }
 
 func usage() {
-	fmt.Println("Usage: ./start [flags] [directory path]")
+	fmt.Println("Usage: ./start [command] [flags] [directory path]")
+	fmt.Println("Commands:")
+	fmt.Println("    serve    serve the index over HTTP as JSON; see -addr")
+	fmt.Println("    stats    print statistics of the index; see -json and -top")
+	fmt.Println("    dump     print the postings of a term, terms by prefix or the terms of a " +
+		"document; see -term, -prefix, -doc and -field")
+	fmt.Println("    verify   check the index opened with -open is consistent; see -repair and -json")
+	fmt.Println("    eval     measure the rankings of the queries of a topics file against TREC " +
+		"qrels; see -topics, -qrels, -k and -json")
 }
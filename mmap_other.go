@@ -0,0 +1,16 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package invertedindex
+
+import "io/ioutil"
+
+// mmapFile reads the file at path into memory, as there is no portable way
+// of mapping it here
+func mmapFile(path string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -2,6 +2,7 @@ package invertedindex
 
 import (
 	"container/list"
+	"sort"
 )
 
 type posting struct {
@@ -31,6 +32,89 @@ func intersectPostingList(p1, p2 list.List) *list.List {
 	return result
 }
 
+// occurrence records where a term appears in a document: its token position,
+// the byte range of the token in the original file and the line and column
+// the token starts at
+type occurrence struct {
+	position, offset, length int
+	line, column             int
+}
+
+// span returns the byte range of the occurrence in its document
+func (o occurrence) span() span {
+	return span{start: o.offset, end: o.offset + o.length, line: o.line, column: o.column}
+}
+
+// findPosition returns the occurrence at the passed token position, if any.
+// occurrences must be sorted by position, as they are when recorded during
+// indexing.
+func findPosition(occurrences []occurrence, position int) (occurrence, bool) {
+	k := sort.Search(len(occurrences), func(j int) bool {
+		return occurrences[j].position >= position
+	})
+	if k < len(occurrences) && occurrences[k].position == position {
+		return occurrences[k], true
+	}
+	return occurrence{}, false
+}
+
+// positionalPostings builds a posting list of the kind positionalIntersect
+// works on out of a term's occurrences, restricted to the passed sorted docIDs
+func positionalPostings(occurrences map[int][]occurrence, docIDs []int) list.List {
+	postings := list.New()
+	for _, docID := range docIDs {
+		if len(occurrences[docID]) == 0 {
+			continue
+		}
+		positions := list.New()
+		for _, o := range occurrences[docID] {
+			positions.PushBack(o.position)
+		}
+		postings.PushBack(posting{docID: docID, positions: positions})
+	}
+	return *postings
+}
+
+// intersectDocIDs returns the docIDs present in both a and b. Both slices
+// must be sorted in increasing order, as the posting lists in Indexer.index are
+func intersectDocIDs(a, b []int) []int {
+	result := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] == b[j] {
+			result = append(result, a[i])
+			i++
+			j++
+		} else if a[i] < b[j] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// unionDocIDs returns the sorted docIDs present in either a or b. Both slices
+// must be sorted in increasing order
+func unionDocIDs(a, b []int) []int {
+	result := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			result = append(result, a[i])
+			i++
+			j++
+		} else if a[i] < b[j] {
+			result = append(result, a[i])
+			i++
+		} else {
+			result = append(result, b[j])
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
 type positionalResult struct {
 	docID, w1Pos, w2Pos int
 }
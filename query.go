@@ -0,0 +1,519 @@
+package invertedindex
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultFuzzyEdits is the edit distance used by a fuzzy term written
+// without one, e.g. "term~"
+const defaultFuzzyEdits = 2
+
+// A clause is a single whitespace separated part of a query, or a quoted
+// phrase. Each clause selects a set of documents; a query matches the
+// documents selected by all of its clauses.
+type clause interface {
+	// docIDs returns the sorted docIDs of the documents the clause selects
+	docIDs(f *field) []int
+	// matches returns the byte ranges of the text the clause matched in the
+	// document with the passed docID
+	matches(f *field, docID int) []span
+	// matchedTerms returns the terms of the field whose occurrences the
+	// clause matches, which are what a ranked search scores it by
+	matchedTerms(f *field) []string
+	// key returns the clause written as in a query, with its terms
+	// normalized as the field indexes them, so that clauses selecting the
+	// same documents the same way have the same key
+	key(f *field) string
+}
+
+// termClause selects the documents containing term
+type termClause struct {
+	term string
+}
+
+func (c termClause) docIDs(f *field) []int {
+	return f.docIDs(f.normalize(c.term))
+}
+
+func (c termClause) matches(f *field, docID int) []span {
+	return termsMatches(f, c.matchedTerms(f), docID)
+}
+
+func (c termClause) matchedTerms(f *field) []string {
+	return []string{f.normalize(c.term)}
+}
+
+func (c termClause) key(f *field) string {
+	return f.normalize(c.term)
+}
+
+// fuzzyClause selects the documents containing any term within maxEdits
+// of term
+type fuzzyClause struct {
+	term     string
+	maxEdits int
+}
+
+// terms returns the dictionary terms within maxEdits of the clause's term
+func (c fuzzyClause) terms(f *field) []string {
+	return newLevenshteinAutomaton(f.normalize(c.term), c.maxEdits).intersect(f.dictionary())
+}
+
+func (c fuzzyClause) docIDs(f *field) []int {
+	return termsDocIDs(f, c.terms(f))
+}
+
+func (c fuzzyClause) matches(f *field, docID int) []span {
+	return termsMatches(f, c.terms(f), docID)
+}
+
+func (c fuzzyClause) matchedTerms(f *field) []string {
+	return c.terms(f)
+}
+
+func (c fuzzyClause) key(f *field) string {
+	return fmt.Sprintf("%s~%d", f.normalize(c.term), c.maxEdits)
+}
+
+// phraseClause selects the documents containing terms as consecutive tokens
+type phraseClause struct {
+	terms []string
+}
+
+func (c phraseClause) docIDs(f *field) []int {
+	candidates := f.docIDs(f.normalize(c.terms[0]))
+	for _, term := range c.terms[1:] {
+		candidates = intersectDocIDs(candidates, f.docIDs(f.normalize(term)))
+	}
+	result := []int{}
+	for _, docID := range candidates {
+		if len(c.matches(f, docID)) > 0 {
+			result = append(result, docID)
+		}
+	}
+	return result
+}
+
+func (c phraseClause) matchedTerms(f *field) []string {
+	terms := make([]string, len(c.terms))
+	for k, term := range c.terms {
+		terms[k] = f.normalize(term)
+	}
+	return terms
+}
+
+func (c phraseClause) key(f *field) string {
+	return `"` + strings.Join(c.matchedTerms(f), " ") + `"`
+}
+
+func (c phraseClause) matches(f *field, docID int) []span {
+	spans := []span{}
+	for _, first := range f.occurrences(f.normalize(c.terms[0]))[docID] {
+		last, found := first, true
+		for k, term := range c.terms[1:] {
+			last, found = findPosition(f.occurrences(f.normalize(term))[docID], first.position+k+1)
+			if !found {
+				break
+			}
+		}
+		if found {
+			s := first.span()
+			s.end = last.offset + last.length
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// proximityClause selects the documents containing left and right within k
+// tokens of each other, in either order
+type proximityClause struct {
+	left, right string
+	k           int
+}
+
+// results returns the positionalResults of the two terms within the
+// documents with the passed docIDs
+func (c proximityClause) results(f *field, docIDs []int) *list.List {
+	p1 := positionalPostings(f.occurrences(f.normalize(c.left)), docIDs)
+	p2 := positionalPostings(f.occurrences(f.normalize(c.right)), docIDs)
+	return positionalIntersect(p1, p2, c.k)
+}
+
+func (c proximityClause) docIDs(f *field) []int {
+	result := []int{}
+	for e := c.results(f, f.docIDs(f.normalize(c.left))).Front(); e != nil; e = e.Next() {
+		// results are ordered by docID but repeat it for every nearby pair
+		docID := e.Value.(positionalResult).docID
+		if len(result) == 0 || result[len(result)-1] != docID {
+			result = append(result, docID)
+		}
+	}
+	return result
+}
+
+func (c proximityClause) matchedTerms(f *field) []string {
+	return []string{f.normalize(c.left), f.normalize(c.right)}
+}
+
+func (c proximityClause) key(f *field) string {
+	return fmt.Sprintf("%s /%d %s", f.normalize(c.left), c.k, f.normalize(c.right))
+}
+
+func (c proximityClause) matches(f *field, docID int) []span {
+	spans := []span{}
+	for e := c.results(f, []int{docID}).Front(); e != nil; e = e.Next() {
+		r := e.Value.(positionalResult)
+		if o, ok := findPosition(f.occurrences(f.normalize(c.left))[docID], r.w1Pos); ok {
+			spans = append(spans, o.span())
+		}
+		if o, ok := findPosition(f.occurrences(f.normalize(c.right))[docID], r.w2Pos); ok {
+			spans = append(spans, o.span())
+		}
+	}
+	return spans
+}
+
+// termsDocIDs returns the sorted docIDs of the documents containing any of
+// the passed terms
+func termsDocIDs(f *field, terms []string) []int {
+	result := []int{}
+	for _, term := range terms {
+		result = unionDocIDs(result, f.docIDs(term))
+	}
+	return result
+}
+
+// termsMatches returns the byte ranges of every occurrence of any of the
+// passed terms in the document with the passed docID
+func termsMatches(f *field, terms []string, docID int) []span {
+	spans := []span{}
+	for _, term := range terms {
+		for _, o := range f.occurrences(term)[docID] {
+			spans = append(spans, o.span())
+		}
+	}
+	return spans
+}
+
+// fieldClause is a clause along with the name of the field it searches and
+// its boost, the factor its score is multiplied by in a ranked search, or 0
+// if it was given none
+type fieldClause struct {
+	clause
+	field string
+	boost float64
+}
+
+// weight returns the factor the clause's score is multiplied by
+func (c fieldClause) weight() float64 {
+	if c.boost == 0 {
+		return 1
+	}
+	return c.boost
+}
+
+// describe returns the clause written as in a query, with its terms
+// normalized as f indexes them and its field and boost spelled out
+func (c fieldClause) describe(f *field) string {
+	s := c.field + ":" + c.key(f)
+	if c.boost != 0 {
+		s += "^" + strconv.FormatFloat(c.boost, 'g', -1, 64)
+	}
+	return s
+}
+
+// queryItem is a whitespace separated word of a query, or the text between
+// the quotes of a phrase, along with the field it was scoped to and its
+// boost
+type queryItem struct {
+	text   string
+	phrase bool
+	field  string
+	boost  float64
+}
+
+// lexQuery splits a query into its words and quoted phrases. A prefix
+// "name:" scopes the word or phrase it precedes to the field of that name if
+// isField reports there is one, and is part of the word otherwise.
+func lexQuery(query string, isField func(name string) bool) ([]queryItem, error) {
+	items := []queryItem{}
+	rest := query
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			return items, nil
+		}
+		item := queryItem{field: bodyField}
+		if n := fieldPrefixLength(rest); n > 0 && isField(rest[:n-1]) {
+			item.field = rest[:n-1]
+			rest = rest[n:]
+		}
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated phrase in query %q", query)
+			}
+			item.text, item.phrase = rest[1:end+1], true
+			rest = rest[end+2:]
+			if strings.HasPrefix(rest, "^") {
+				end := strings.IndexFunc(rest, unicode.IsSpace)
+				if end < 0 {
+					end = len(rest)
+				}
+				boost, err := parseBoost(rest[1:end])
+				if err != nil {
+					return nil, err
+				}
+				item.boost = boost
+				rest = rest[end:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			item.text = rest[:end]
+			rest = rest[end:]
+			if caret := strings.LastIndex(item.text, "^"); caret > 0 {
+				// a caret not followed by a positive number is part of the
+				// term, like that of x^0 or e^-1
+				if boost, err := parseBoost(item.text[caret+1:]); err == nil {
+					item.text, item.boost = item.text[:caret], boost
+				}
+			}
+		}
+		items = append(items, item)
+	}
+}
+
+// parseBoost parses the boost following the caret of a clause, which must
+// be a positive number
+func parseBoost(s string) (float64, error) {
+	boost, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(boost > 0) || math.IsInf(boost, 0) {
+		return 0, fmt.Errorf("invalid boost ^%s", s)
+	}
+	return boost, nil
+}
+
+// fieldPrefixLength returns the length of the field scope "name:" at the
+// start of s, or 0 if there is none. A scope must be followed by the term or
+// phrase it applies to.
+func fieldPrefixLength(s string) int {
+	for k, r := range s {
+		switch {
+		case r == ':' && k > 0:
+			if next, _ := utf8.DecodeRuneInString(s[k+1:]); k+1 < len(s) && !unicode.IsSpace(next) {
+				return k + 1
+			}
+			return 0
+		case r == '_' || unicode.IsLetter(r) || (k > 0 && unicode.IsDigit(r)):
+			continue
+		}
+		return 0
+	}
+	return 0
+}
+
+// parseQuery splits a query into its clauses. Supported syntax:
+//
+//	term       documents containing term
+//	term~N     documents containing a term within edit distance N of term;
+//	           N defaults to 2 when omitted
+//	/re/       documents containing a term matched in full by the regular
+//	           expression re, which may not contain whitespace
+//	"a b c"    documents containing the terms a, b and c in that order as
+//	           consecutive tokens
+//	a /k b     documents containing the terms a and b within k tokens of
+//	           each other, in either order
+//
+// Any of these may be scoped to a field other than the body by prefixing
+// it with the field name, e.g. name:readme, ext:/go|rs/ or path:"a b". A
+// prefix that is not the name of a field, as isField tells, is part of the
+// term, so that TODO:fix or http://host search the body; terms starting
+// with the name of a field can be searched for as a quoted phrase. Any but
+// a proximity may be boosted by suffixing it with ^ and a positive number,
+// e.g. readme^2 or "a b"^0.5, which multiplies its score in a ranked search.
+// Any other caret ending a term is part of it, e.g. that of x^0 or e^-1.
+func parseQuery(query string, isField func(name string) bool) ([]fieldClause, error) {
+	items, err := lexQuery(query, isField)
+	if err != nil {
+		return nil, err
+	}
+	clauses := []fieldClause{}
+	for k := 0; k < len(items); k++ {
+		var c clause
+		var err error
+		switch {
+		case k+2 < len(items) && proximityDistance(items[k+1]) >= 0:
+			c, err = parseProximity(items[k], items[k+1], items[k+2])
+			k += 2
+		case proximityDistance(items[k]) >= 0:
+			err = fmt.Errorf("proximity operator %s needs a term on either side", items[k].text)
+		case items[k].phrase:
+			c, err = parsePhrase(items[k].text)
+		default:
+			c, err = parseClause(items[k].text)
+		}
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, fieldClause{clause: c, field: items[k].field, boost: items[k].boost})
+	}
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	return clauses, nil
+}
+
+// proximityDistance returns k if item is the proximity operator /k, and -1
+// otherwise
+func proximityDistance(item queryItem) int {
+	if item.phrase || len(item.text) < 2 || item.text[0] != '/' {
+		return -1
+	}
+	k, err := strconv.Atoi(item.text[1:])
+	if err != nil || k < 0 || item.text[1] == '+' || item.text[1] == '-' {
+		return -1
+	}
+	return k
+}
+
+// parseProximity parses a proximity operator and its operands, none of
+// which may be boosted
+func parseProximity(left, operator, right queryItem) (clause, error) {
+	k := proximityDistance(operator)
+	if left.boost != 0 || operator.boost != 0 || right.boost != 0 {
+		return nil, fmt.Errorf("/%d and its operands cannot be boosted", k)
+	}
+	l, err := proximityOperand(left, k)
+	if err != nil {
+		return nil, err
+	}
+	r, err := proximityOperand(right, k)
+	if err != nil {
+		return nil, err
+	}
+	if left.field != right.field {
+		return nil, fmt.Errorf("operands of /%d must search the same field", k)
+	}
+	return proximityClause{left: l, right: r, k: k}, nil
+}
+
+// proximityOperand returns the term of an operand of a proximity operator,
+// which must be a plain term
+func proximityOperand(item queryItem, k int) (string, error) {
+	if !item.phrase {
+		if c, err := parseClause(item.text); err == nil {
+			if t, ok := c.(termClause); ok {
+				return t.term, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("operands of /%d must be plain terms: %s", k, item.text)
+}
+
+// parsePhrase parses the text between the quotes of a phrase. A phrase of a
+// single term is just that term.
+func parsePhrase(s string) (clause, error) {
+	terms := strings.Fields(s)
+	switch len(terms) {
+	case 0:
+		return nil, fmt.Errorf("empty phrase in query")
+	case 1:
+		return termClause{term: terms[0]}, nil
+	}
+	return phraseClause{terms: terms}, nil
+}
+
+func parseClause(s string) (clause, error) {
+	if len(s) >= 2 && s[0] == '/' && s[len(s)-1] == '/' {
+		c, err := newRegexClause(s[1 : len(s)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %s", s, err)
+		}
+		return c, nil
+	}
+	tilde := strings.LastIndex(s, "~")
+	if tilde <= 0 {
+		return termClause{term: s}, nil
+	}
+	maxEdits := defaultFuzzyEdits
+	if tilde < len(s)-1 {
+		n, err := strconv.Atoi(s[tilde+1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid edit distance in %q", s)
+		}
+		maxEdits = n
+	}
+	return fuzzyClause{term: s[:tilde], maxEdits: maxEdits}, nil
+}
+
+// Search evaluates query against the index and returns the sorted docIDs of
+// the matching documents. The clauses of the query are combined with AND.
+func (i *Indexer) Search(query string) ([]int, error) {
+	clauses, fields, err := i.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	key := i.queryKey("search", clauses, fields)
+	if cached, ok := i.queryCache.get(key); ok {
+		return append([]int{}, cached.([]int)...), nil
+	}
+	result := match(clauses, fields)
+	if err := i.diskError(); err != nil {
+		return nil, err
+	}
+	i.queryCache.add(key, append([]int{}, result...))
+	return result, nil
+}
+
+// prepare parses query and looks up the fields its clauses search
+func (i *Indexer) prepare(query string) ([]fieldClause, []*field, error) {
+	clauses, err := parseQuery(query, i.hasField)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make([]*field, len(clauses))
+	for k, c := range clauses {
+		if fields[k], err = i.field(c.field); err != nil {
+			return nil, nil, err
+		}
+	}
+	return clauses, fields, nil
+}
+
+// match returns the sorted docIDs of the documents matching every clause,
+// each searching the field of the same index
+func match(clauses []fieldClause, fields []*field) []int {
+	result := append([]int{}, clauses[0].docIDs(fields[0])...)
+	for k, c := range clauses[1:] {
+		if len(result) == 0 {
+			break
+		}
+		result = intersectDocIDs(result, c.docIDs(fields[k+1]))
+	}
+	return result
+}
+
+// hasField tells whether the index has a field with the passed name
+func (i *Indexer) hasField(name string) bool {
+	_, ok := i.fields[name]
+	return ok
+}
+
+// field returns the field with the passed name
+func (i *Indexer) field(name string) (*field, error) {
+	f, ok := i.fields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", name)
+	}
+	return f, nil
+}
@@ -0,0 +1,112 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for parsing queries and evaluating them against an index built
+// from test_files/index_files/multi, where a.txt, b.txt and c.txt are
+// assigned docIDs 0, 1 and 2
+
+// isFileField tells whether name is one of the fields every file is indexed
+// under, for parsing queries without an index
+func isFileField(name string) bool {
+	return name == bodyField || name == pathField || name == nameField || name == extField
+}
+
+func assertSearchResult(t *testing.T, indexer *Indexer, query string, expected []int) {
+	actual, err := indexer.Search(query)
+	if err != nil {
+		t.Errorf("query %q returned error: %s", query, err)
+		return
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("query %q expected docIDs: %v, actual: %v", query, expected, actual)
+	}
+}
+
+func setUpMultiIndexer(t *testing.T) *Indexer {
+	return setUpIndexer(t, IndexerFlags{}, filepath.Join(indexpath, "multi"))
+}
+
+func TestSearchSingleTerm(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "gamma", []int{1, 2})
+	assertSearchResult(t, indexer, "missing", []int{})
+}
+
+func TestSearchConjunction(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "alpha beta", []int{0, 2})
+	assertSearchResult(t, indexer, "alpha gamma epsilon", []int{2})
+	assertSearchResult(t, indexer, "alpha missing", []int{})
+}
+
+func TestSearchFuzzyTerm(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "alhpa~1", []int{})
+	assertSearchResult(t, indexer, "alhpa~2", []int{0, 2})
+	assertSearchResult(t, indexer, "gama~1", []int{1, 2})
+	// zeta is a single substitution away from beta, which every document contains
+	assertSearchResult(t, indexer, "zeta~1", []int{0, 1, 2})
+}
+
+func TestSearchFuzzyDefaultDistance(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "epslon~", []int{2})
+}
+
+func TestSearchFuzzyAndExactTerms(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "gamma~0 alpah~2", []int{2})
+}
+
+func TestParseInvalidQueries(t *testing.T) {
+	for _, query := range []string{"", "  ", "alpha~x", "alpha~-1"} {
+		if _, err := parseQuery(query, isFileField); err == nil {
+			t.Errorf("expected error parsing query %q", query)
+		}
+	}
+}
+
+func TestSearchPhrase(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, `"alpha beta"`, []int{0})
+	assertSearchResult(t, indexer, `"beta alpha"`, []int{2})
+	assertSearchResult(t, indexer, `"alpha gamma epsilon"`, []int{2})
+	assertSearchResult(t, indexer, `"gamma alpha"`, []int{})
+}
+
+func TestSearchPhraseAndTerms(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, `gamma "beta  alpha" epsilon`, []int{2})
+	assertSearchResult(t, indexer, `"gamma gamma" beta`, []int{1})
+	assertSearchResult(t, indexer, `"gamma"`, []int{1, 2})
+}
+
+func TestParseInvalidPhrases(t *testing.T) {
+	for _, query := range []string{`"alpha beta`, `""`, `alpha " "`} {
+		if _, err := parseQuery(query, isFileField); err == nil {
+			t.Errorf("expected error parsing query %q", query)
+		}
+	}
+}
+
+func TestSearchProximity(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "alpha /1 beta", []int{0, 2})
+	assertSearchResult(t, indexer, "beta /1 gamma", []int{1})
+	assertSearchResult(t, indexer, "beta /2 gamma", []int{1, 2})
+	assertSearchResult(t, indexer, "epsilon /2 beta", []int{})
+	assertSearchResult(t, indexer, "epsilon /3 beta gamma", []int{2})
+}
+
+func TestParseInvalidProximity(t *testing.T) {
+	for _, query := range []string{"/2", "alpha /2", `"alpha beta" /2 gamma`, "alpha~1 /2 beta", "/2 beta"} {
+		if _, err := parseQuery(query, isFileField); err == nil {
+			t.Errorf("expected error parsing query %q", query)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package invertedindex
+
+import (
+	"math"
+	"sort"
+)
+
+// Hit is a document matched by a ranked search, along with its score
+type Hit struct {
+	DocID int     `json:"docID"`
+	Score float64 `json:"score"`
+}
+
+// termKey names a term of a field
+type termKey struct {
+	field, term string
+}
+
+// collectionStats are the statistics of a collection of documents that
+// scores depend on: how many documents there are, and how many of them
+// contain each term of a query. Those of an index split into shards are
+// summed across the shards, so that a document scores the same whichever
+// shard holds it.
+type collectionStats struct {
+	documents         int
+	documentFrequency map[termKey]int
+}
+
+func newCollectionStats() collectionStats {
+	return collectionStats{documentFrequency: make(map[termKey]int)}
+}
+
+// add adds the statistics of other to those of the collection
+func (s *collectionStats) add(other collectionStats) {
+	s.documents += other.documents
+	for key, n := range other.documentFrequency {
+		s.documentFrequency[key] += n
+	}
+}
+
+// idf returns the inverse document frequency of a term, ln(1 + N/df), or
+// 0 if no document contains it
+func (s collectionStats) idf(key termKey) float64 {
+	df := s.documentFrequency[key]
+	if df == 0 {
+		return 0
+	}
+	return math.Log(1 + float64(s.documents)/float64(df))
+}
+
+// RankedSearch evaluates query against the index like Search, but returns
+// the matching documents ordered by decreasing tf-idf score, and by docID
+// when scores tie. A document scores (1 + ln tf) * idf for every term a
+// clause of the query matches in it, tf being the number of occurrences of
+// the term in the document, times the boost of the clause. Explain breaks
+// the score of a document down.
+func (i *Indexer) RankedSearch(query string) ([]Hit, error) {
+	clauses, fields, err := i.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	key := i.queryKey("rank", clauses, fields)
+	if cached, ok := i.queryCache.get(key); ok {
+		return append([]Hit{}, cached.([]Hit)...), nil
+	}
+	stats := queryStats(clauses, fields)
+	stats.documents = i.documentCount()
+	hits := rank(clauses, fields, match(clauses, fields), stats)
+	if err := i.diskError(); err != nil {
+		return nil, err
+	}
+	i.queryCache.add(key, append([]Hit{}, hits...))
+	return hits, nil
+}
+
+// queryStats returns the document frequencies of the terms the clauses
+// match in their fields; the number of documents is left to the caller
+func queryStats(clauses []fieldClause, fields []*field) collectionStats {
+	stats := newCollectionStats()
+	for k, c := range clauses {
+		for _, term := range c.matchedTerms(fields[k]) {
+			stats.documentFrequency[termKey{c.field, term}] = len(fields[k].docIDs(term))
+		}
+	}
+	return stats
+}
+
+// rank scores the documents with the passed docIDs, which match every
+// clause, and orders them by score
+func rank(clauses []fieldClause, fields []*field, docIDs []int, stats collectionStats) []Hit {
+	hits := make([]Hit, len(docIDs))
+	for j, docID := range docIDs {
+		hits[j] = Hit{DocID: docID, Score: explain(clauses, fields, docID, stats).Score}
+	}
+	sort.Sort(byScore(hits))
+	return hits
+}
+
+// termFrequency returns the number of occurrences of term in the document
+// with the passed docID; in fields indexed without positions a document
+// containing the term counts one
+func termFrequency(f *field, term string, docID int) int {
+	docIDs, occurrences := f.lookup(term)
+	if occurrences != nil {
+		return len(occurrences[docID])
+	}
+	if k := sort.SearchInts(docIDs, docID); k < len(docIDs) && docIDs[k] == docID {
+		return 1
+	}
+	return 0
+}
+
+type byScore []Hit
+
+func (s byScore) Len() int      { return len(s) }
+func (s byScore) Swap(a, b int) { s[a], s[b] = s[b], s[a] }
+func (s byScore) Less(a, b int) bool {
+	if s[a].Score != s[b].Score {
+		return s[a].Score > s[b].Score
+	}
+	return s[a].DocID < s[b].DocID
+}
@@ -0,0 +1,52 @@
+package invertedindex
+
+import (
+	"math"
+	"testing"
+)
+
+// Tests for ranking the results of a search by tf-idf
+
+func TestRankedSearch(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, indexpath)
+	assertRankedSearch(t, indexer, "alpha", []Hit{
+		{DocID: 0, Score: (1 + math.Log(3)) * math.Log(2)},
+		{DocID: 1, Score: (1 + math.Log(2)) * math.Log(2)},
+		{DocID: 2, Score: math.Log(2)},
+	})
+	// ties are broken by docID
+	assertRankedSearch(t, indexer, "gamma", []Hit{
+		{DocID: 1, Score: math.Log(2.5)},
+		{DocID: 2, Score: math.Log(2.5)},
+	})
+	assertRankedSearch(t, indexer, "alpha delta", []Hit{
+		{DocID: 1, Score: (1 + math.Log(2)) * (math.Log(2) + math.Log(4))},
+	})
+	// every term a fuzzy clause matches scores
+	assertRankedSearch(t, indexer, "delte~1", []Hit{
+		{DocID: 1, Score: (1 + math.Log(2)) * math.Log(4)},
+	})
+	// fields without positions count one occurrence per document
+	assertRankedSearch(t, indexer, "name:mixed", []Hit{{DocID: 1, Score: math.Log(4)}})
+	assertRankedSearch(t, indexer, "missing", []Hit{})
+	assertRankedSearch(t, indexer, "nofield:alpha", []Hit{})
+}
+
+func assertRankedSearch(t *testing.T, indexer interface {
+	RankedSearch(string) ([]Hit, error)
+}, query string, expected []Hit) {
+	actual, err := indexer.RankedSearch(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("Expected hits for %q: %v, actual: %v", query, expected, actual)
+		return
+	}
+	for k := range actual {
+		if actual[k].DocID != expected[k].DocID || math.Abs(actual[k].Score-expected[k].Score) > 1e-9 {
+			t.Errorf("Expected hits for %q: %v, actual: %v", query, expected, actual)
+			return
+		}
+	}
+}
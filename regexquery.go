@@ -0,0 +1,188 @@
+package invertedindex
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// regexClause selects the documents containing any term matched in full by
+// a regular expression. Running the expression against every term of a
+// large dictionary is slow, so candidate terms are first narrowed down with
+// the expression's literal prefix, which bounds a range of the sorted
+// dictionary, and with the trigrams of the literal strings any match must
+// contain, which are looked up in a trigram index of the dictionary.
+type regexClause struct {
+	pattern  string
+	re       *regexp.Regexp
+	prefix   string
+	trigrams []string
+}
+
+// newRegexClause compiles pattern, which matches whole terms as though it
+// were anchored at both ends
+func newRegexClause(pattern string) (regexClause, error) {
+	anchored := `^(?:` + pattern + `)$`
+	re, err := regexp.Compile(anchored)
+	if err != nil {
+		return regexClause{}, err
+	}
+	// regexp.Compile has already validated the pattern
+	tree, _ := syntax.Parse(anchored, syntax.Perl)
+	tree = tree.Simplify()
+	c := regexClause{pattern: pattern, re: re, prefix: literalPrefix(tree)}
+	seen := make(map[string]bool)
+	for _, literal := range requiredLiterals(tree) {
+		for _, trigram := range trigramsOf(literal) {
+			if !seen[trigram] {
+				seen[trigram] = true
+				c.trigrams = append(c.trigrams, trigram)
+			}
+		}
+	}
+	return c, nil
+}
+
+// terms returns the dictionary terms matched by the regular expression
+func (c regexClause) terms(f *field) []string {
+	terms := []string{}
+	for _, term := range c.candidates(f) {
+		if c.re.MatchString(term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func (c regexClause) docIDs(f *field) []int {
+	return termsDocIDs(f, c.terms(f))
+}
+
+func (c regexClause) matches(f *field, docID int) []span {
+	return termsMatches(f, c.terms(f), docID)
+}
+
+func (c regexClause) matchedTerms(f *field) []string {
+	return c.terms(f)
+}
+
+func (c regexClause) key(f *field) string {
+	return "/" + c.pattern + "/"
+}
+
+// candidates returns the dictionary terms that pass the prefix and trigram
+// filters; only these are checked against the regular expression
+func (c regexClause) candidates(f *field) []string {
+	terms := f.dictionary()
+	lo, hi := prefixRange(terms, c.prefix)
+	if len(c.trigrams) == 0 {
+		return terms[lo:hi]
+	}
+	trigrams := f.trigramIndex()
+	var matching []int
+	for k, trigram := range c.trigrams {
+		if k == 0 {
+			matching = trigrams[trigram]
+		} else {
+			matching = intersectDocIDs(matching, trigrams[trigram])
+		}
+		if len(matching) == 0 {
+			return []string{}
+		}
+	}
+	// trigram postings hold dictionary indexes, so narrowing them to the
+	// prefix range is another pair of binary searches
+	start := sort.SearchInts(matching, lo)
+	end := sort.SearchInts(matching, hi)
+	result := make([]string, 0, end-start)
+	for _, k := range matching[start:end] {
+		result = append(result, terms[k])
+	}
+	return result
+}
+
+// prefixRange returns the bounds [lo, hi) of the terms in a sorted
+// dictionary that start with prefix
+func prefixRange(terms []string, prefix string) (int, int) {
+	lo := sort.SearchStrings(terms, prefix)
+	rest := terms[lo:]
+	hi := lo + sort.Search(len(rest), func(j int) bool {
+		return !strings.HasPrefix(rest[j], prefix)
+	})
+	return lo, hi
+}
+
+// trigramsOf returns every three byte substring of s, in order of occurrence
+func trigramsOf(s string) []string {
+	if len(s) < 3 {
+		return nil
+	}
+	trigrams := make([]string, 0, len(s)-2)
+	for k := 0; k+3 <= len(s); k++ {
+		trigrams = append(trigrams, s[k:k+3])
+	}
+	return trigrams
+}
+
+// literalPrefix returns the literal string every match of re starts with
+func literalPrefix(re *syntax.Regexp) string {
+	var prefix []rune
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	for _, sub := range subs {
+		if sub.Op == syntax.OpBeginText {
+			continue
+		}
+		if !isCaseSensitiveLiteral(sub) {
+			break
+		}
+		prefix = append(prefix, sub.Rune...)
+	}
+	return string(prefix)
+}
+
+// requiredLiterals returns literal strings that must appear in every match
+// of re. It is conservative: any construct it does not understand, such as
+// alternation, contributes nothing rather than a wrong answer.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if isCaseSensitiveLiteral(re) {
+			return []string{string(re.Rune)}
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// adjacent literals form a longer literal, so runs of them are
+		// joined before recursing into everything else
+		literals := []string{}
+		var run []rune
+		for _, sub := range re.Sub {
+			if isCaseSensitiveLiteral(sub) {
+				run = append(run, sub.Rune...)
+				continue
+			}
+			if len(run) > 0 {
+				literals = append(literals, string(run))
+				run = nil
+			}
+			literals = append(literals, requiredLiterals(sub)...)
+		}
+		if len(run) > 0 {
+			literals = append(literals, string(run))
+		}
+		return literals
+	}
+	return nil
+}
+
+func isCaseSensitiveLiteral(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase == 0
+}
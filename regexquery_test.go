@@ -0,0 +1,106 @@
+package invertedindex
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Tests for regular expression term queries and the prefilters that keep
+// them from running the expression against every dictionary term
+
+func setUpRegexIndexer(terms ...string) *Indexer {
+	body := newField(false)
+	for docID, term := range terms {
+		body.add(term, docID)
+	}
+	return &Indexer{index: body.postings, fields: map[string]*field{bodyField: body}}
+}
+
+func assertRegexCandidates(t *testing.T, indexer *Indexer, pattern string, expected []string) {
+	c, err := newRegexClause(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := c.candidates(indexer.fields[bodyField])
+	sort.Strings(expected)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("/%s/ expected candidates: %v, actual: %v", pattern, expected, actual)
+	}
+}
+
+func TestRegexLiteralPrefix(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		"colou?r":  "colo",
+		"foo.*bar": "foo",
+		"abc|abd":  "ab",
+		".*abc":    "",
+		"(?i)abc":  "",
+	} {
+		c, err := newRegexClause(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.prefix != expected {
+			t.Errorf("/%s/ expected prefix: %q, actual: %q", pattern, expected, c.prefix)
+		}
+	}
+}
+
+func TestRegexRequiredTrigrams(t *testing.T) {
+	for pattern, expected := range map[string][]string{
+		"colou?r":     {"col", "olo"},
+		".*ing":       {"ing"},
+		"x(abcd)+y":   {"abc", "bcd"},
+		"abc|xyz":     nil,
+		"a[bc]d":      nil,
+		"(?i)hello.*": nil,
+	} {
+		c, err := newRegexClause(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.trigrams, expected) {
+			t.Errorf("/%s/ expected trigrams: %v, actual: %v", pattern, expected, c.trigrams)
+		}
+	}
+}
+
+func TestRegexCandidatesUsePrefix(t *testing.T) {
+	indexer := setUpRegexIndexer("alpha", "beta", "co", "color", "colour", "cold", "dog")
+	assertRegexCandidates(t, indexer, "co.*", []string{"co", "color", "colour", "cold"})
+}
+
+func TestRegexCandidatesUseTrigrams(t *testing.T) {
+	indexer := setUpRegexIndexer("walking", "talked", "singing", "ingot", "bring", "in")
+	assertRegexCandidates(t, indexer, ".*ing", []string{"walking", "singing", "ingot", "bring"})
+}
+
+func TestRegexCandidatesUsePrefixAndTrigrams(t *testing.T) {
+	indexer := setUpRegexIndexer("walking", "walrus", "singing", "wring", "wing")
+	assertRegexCandidates(t, indexer, "w.*ing", []string{"walking", "wring", "wing"})
+}
+
+func TestRegexCandidatesMissingTrigram(t *testing.T) {
+	indexer := setUpRegexIndexer("alpha", "beta")
+	assertRegexCandidates(t, indexer, ".*xyz.*", []string{})
+}
+
+func TestRegexMatchesWholeTerm(t *testing.T) {
+	indexer := setUpRegexIndexer("color", "colour", "colors", "discolor")
+	assertSearchResult(t, indexer, "/colou?r/", []int{0, 1})
+}
+
+func TestSearchRegexTerm(t *testing.T) {
+	indexer := setUpMultiIndexer(t)
+	assertSearchResult(t, indexer, "/.*a/", []int{0, 1, 2})
+	assertSearchResult(t, indexer, "/g.*/", []int{1, 2})
+	assertSearchResult(t, indexer, "/(alpha|epsilon)/ gamma", []int{2})
+	assertSearchResult(t, indexer, "/alp/", []int{})
+}
+
+func TestParseInvalidRegex(t *testing.T) {
+	if _, err := parseQuery("/colou(r/", isFileField); err == nil {
+		t.Error("expected error parsing invalid regular expression")
+	}
+}
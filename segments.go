@@ -0,0 +1,296 @@
+package invertedindex
+
+import (
+	"sort"
+	"sync"
+)
+
+// MergePolicy decides which segments of a SegmentedIndex are merged. It is a
+// logarithmic policy: segments are put in tiers by size, tier n holding the
+// segments of between MinSegmentSize*MergeFactor^n and
+// MinSegmentSize*MergeFactor^(n+1) documents, and whenever MergeFactor
+// adjacent segments share a tier they are merged into one of the next tier.
+// The number of segments thus stays logarithmic in the number of documents,
+// and every document is merged a logarithmic number of times.
+type MergePolicy struct {
+	MergeFactor    int
+	MinSegmentSize int
+}
+
+// DefaultMergePolicy is the merge policy of a SegmentedIndex unless told
+// otherwise
+var DefaultMergePolicy = MergePolicy{MergeFactor: 10, MinSegmentSize: 100}
+
+// tier returns the tier of a segment of the passed number of documents
+func (p MergePolicy) tier(size int) int {
+	tier := 0
+	for limit := p.MinSegmentSize * p.MergeFactor; size >= limit; limit *= p.MergeFactor {
+		tier++
+	}
+	return tier
+}
+
+// findMerge returns the range [start, end) of the segments of the passed
+// sizes to merge next, oldest first, and whether there is any. A policy
+// whose tiers would not grow merges nothing.
+func (p MergePolicy) findMerge(sizes []int) (int, int, bool) {
+	if p.MergeFactor < 2 || p.MinSegmentSize < 1 {
+		return 0, 0, false
+	}
+	for start := 0; start < len(sizes); {
+		end := start + 1
+		for end < len(sizes) && p.tier(sizes[end]) == p.tier(sizes[start]) {
+			end++
+		}
+		if end-start >= p.MergeFactor {
+			return start, start + p.MergeFactor, true
+		}
+		start = end
+	}
+	return 0, 0, false
+}
+
+// segment is an immutable part of a SegmentedIndex, holding the documents
+// with docIDs from base on
+type segment struct {
+	base  int
+	index *Indexer
+}
+
+// SegmentedIndex is an index split into immutable segments. Every batch of
+// documents added goes into a segment of its own, and segments are merged in
+// the background as the merge policy says. Queries search every live
+// segment, and docIDs stay the same across merges. A SegmentedIndex is safe
+// for concurrent use.
+type SegmentedIndex struct {
+	policy MergePolicy
+	// guards segments and nextDocID; segments are replaced, never modified
+	mu        sync.RWMutex
+	segments  []segment
+	nextDocID int
+	// serializes adding segments, and tells whether a merge is running
+	addMu   sync.Mutex
+	merging bool
+	merges  sync.WaitGroup
+}
+
+// NewSegmentedIndex returns an empty segmented index merging segments by
+// the passed policy. A MinSegmentSize below 1 is taken as 1.
+func NewSegmentedIndex(policy MergePolicy) *SegmentedIndex {
+	if policy.MinSegmentSize < 1 {
+		policy.MinSegmentSize = 1
+	}
+	return &SegmentedIndex{policy: policy}
+}
+
+// Add indexes the file or directory at path as a new segment, as
+// Indexer.BuildIndex would with the passed flags, and starts merging
+// segments in the background if the merge policy calls for it
+func (s *SegmentedIndex) Add(flags IndexerFlags, path string) {
+	index := new(Indexer)
+	index.BuildIndex(flags, path)
+	s.addMu.Lock()
+	defer s.addMu.Unlock()
+	s.mu.Lock()
+	s.segments = append(s.segments, segment{base: s.nextDocID, index: index})
+	s.nextDocID += index.nextDocID
+	s.mu.Unlock()
+	if !s.merging {
+		s.merging = true
+		s.merges.Add(1)
+		go s.merge()
+	}
+}
+
+// merge merges segments until the merge policy finds nothing left to merge.
+// Only one merge runs at a time, and as segments are only ever appended
+// meanwhile, the segments chosen keep their places.
+func (s *SegmentedIndex) merge() {
+	defer s.merges.Done()
+	for {
+		s.addMu.Lock()
+		live := s.live()
+		sizes := make([]int, len(live))
+		for k, seg := range live {
+			sizes[k] = seg.index.documentCount()
+		}
+		start, end, ok := s.policy.findMerge(sizes)
+		if !ok {
+			s.merging = false
+			s.addMu.Unlock()
+			return
+		}
+		s.addMu.Unlock()
+
+		merged := mergeSegments(live[start:end])
+		s.mu.Lock()
+		segments := make([]segment, 0, len(s.segments)-(end-start)+1)
+		segments = append(segments, s.segments[:start]...)
+		segments = append(segments, merged)
+		segments = append(segments, s.segments[end:]...)
+		s.segments = segments
+		s.mu.Unlock()
+	}
+}
+
+// Wait waits for the merges running in the background to finish
+func (s *SegmentedIndex) Wait() {
+	s.merges.Wait()
+}
+
+// live returns the live segments
+func (s *SegmentedIndex) live() []segment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.segments
+}
+
+// SegmentSizes returns the number of documents of each live segment, oldest
+// first
+func (s *SegmentedIndex) SegmentSizes() []int {
+	live := s.live()
+	sizes := make([]int, len(live))
+	for k, seg := range live {
+		sizes[k] = seg.index.documentCount()
+	}
+	return sizes
+}
+
+// Search returns the sorted docIDs of the documents of every live segment
+// matching query. A field only needs to be known to one of the segments.
+func (s *SegmentedIndex) Search(query string) ([]int, error) {
+	live := s.live()
+	clauses, err := parseQuery(query, func(name string) bool {
+		for _, seg := range live {
+			if seg.index.hasField(name) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	docIDs := []int{}
+	for _, seg := range live {
+		missing := false
+		for _, c := range clauses {
+			if !seg.index.hasField(c.field) {
+				missing = true
+			}
+		}
+		if missing {
+			// no document of the segment can match
+			continue
+		}
+		matches, err := seg.index.Search(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, docID := range matches {
+			docIDs = append(docIDs, seg.base+docID)
+		}
+	}
+	return docIDs, nil
+}
+
+// Path returns the path of the document with the passed docID, and whether
+// such a document was indexed
+func (s *SegmentedIndex) Path(docID int) (string, bool) {
+	seg, ok := s.segmentOf(docID)
+	if !ok {
+		return "", false
+	}
+	return seg.index.Path(docID - seg.base)
+}
+
+// Snippets returns the snippets of the document with the passed docID
+// matching query, as Indexer.Snippets does
+func (s *SegmentedIndex) Snippets(query string, docID int) ([]Snippet, error) {
+	seg, ok := s.segmentOf(docID)
+	if !ok {
+		seg = segment{index: new(Indexer)}
+	}
+	return seg.index.Snippets(query, docID-seg.base)
+}
+
+// segmentOf returns the live segment holding the passed docID
+func (s *SegmentedIndex) segmentOf(docID int) (segment, bool) {
+	live := s.live()
+	k := sort.Search(len(live), func(k int) bool { return live[k].base > docID }) - 1
+	if k < 0 || docID < 0 {
+		return segment{}, false
+	}
+	return live[k], true
+}
+
+// mergeSegments merges adjacent segments, oldest first, into one. The
+// documents keep their docIDs. The segments may be searched meanwhile, so
+// their documents are copied through record, which guards those of a
+// segment read from disk as they are decoded.
+func mergeSegments(segments []segment) segment {
+	merged := &Indexer{
+		documents:   make(map[int]string),
+		fields:      make(map[string]*field),
+		stored:      make(map[int]map[string]string),
+		externalIDs: make(map[int]string),
+	}
+	merged.invalidate()
+	base := segments[0].base
+	for _, seg := range segments {
+		offset := seg.base - base
+		index := seg.index
+		for docID := 0; docID < index.nextDocID; docID++ {
+			record, ok := index.record(docID)
+			if !ok {
+				continue
+			}
+			merged.documents[docID+offset] = record.Path
+			if record.Stored != nil {
+				merged.stored[docID+offset] = record.Stored
+			}
+			if record.ExternalID != nil {
+				merged.externalIDs[docID+offset] = *record.ExternalID
+			}
+		}
+		for name, f := range index.fields {
+			f.loadAll()
+			g, ok := merged.fields[name]
+			if !ok {
+				g = newField(f.foldCase)
+				merged.fields[name] = g
+			}
+			g.append(f, offset)
+		}
+		merged.nextDocID = offset + index.nextDocID
+	}
+	if merged.fields[bodyField] == nil {
+		merged.fields[bodyField] = newField(false)
+	}
+	merged.index = merged.fields[bodyField].postings
+	return segment{base: base, index: merged}
+}
+
+// append adds the postings and positions of other to those of the field,
+// with offset added to their docIDs. Every docID of other plus offset must be
+// greater than those of the field.
+func (f *field) append(other *field, offset int) {
+	for term, docIDs := range other.postings {
+		if _, ok := f.postings[term]; !ok {
+			f.terms = nil
+			f.trigrams = nil
+		}
+		for _, docID := range docIDs {
+			f.postings[term] = append(f.postings[term], docID+offset)
+		}
+	}
+	for term, occurrences := range other.positions {
+		if f.positions[term] == nil {
+			f.positions[term] = make(map[int][]occurrence)
+		}
+		for docID, list := range occurrences {
+			f.positions[term][docID+offset] = list
+		}
+	}
+	f.size += other.size
+}
@@ -0,0 +1,176 @@
+package invertedindex
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Tests for indexes split into segments merged in the background
+
+func TestMergePolicyTiers(t *testing.T) {
+	policy := MergePolicy{MergeFactor: 10, MinSegmentSize: 100}
+	for size, tier := range map[int]int{0: 0, 999: 0, 1000: 1, 9999: 1, 10000: 2} {
+		if actual := policy.tier(size); actual != tier {
+			t.Errorf("Expected a segment of %d documents in tier %d, actual: %d", size, tier, actual)
+		}
+	}
+}
+
+func TestMergePolicyFindMerge(t *testing.T) {
+	policy := MergePolicy{MergeFactor: 3, MinSegmentSize: 1}
+	assertFindMerge(t, policy, []int{1, 1}, 0, 0, false)
+	assertFindMerge(t, policy, []int{1, 1, 1}, 0, 3, true)
+	assertFindMerge(t, policy, []int{5, 1, 2, 1, 1}, 1, 4, true)
+	assertFindMerge(t, policy, []int{3, 3, 1, 1, 5}, 0, 0, false)
+	assertFindMerge(t, policy, []int{9, 3, 5, 4, 1}, 1, 4, true)
+	assertFindMerge(t, MergePolicy{}, []int{1, 1, 1}, 0, 0, false)
+	assertFindMerge(t, MergePolicy{MergeFactor: 2}, []int{1, 1, 1}, 0, 0, false)
+}
+
+func TestSegmentedIndexZeroMinSegmentSize(t *testing.T) {
+	s := NewSegmentedIndex(MergePolicy{MergeFactor: 2})
+	s.Add(IndexerFlags{}, filepath.Join(fieldpath, "README.md"))
+	s.Add(IndexerFlags{}, filepath.Join(fieldpath, "src/lib.rs"))
+	s.Wait()
+	// segments are sized as though the minimum were 1
+	assertSegmentSizes(t, s, []int{2})
+}
+
+func TestSegmentedIndexSearch(t *testing.T) {
+	// with a merge factor of 0 segments are never merged
+	s := NewSegmentedIndex(MergePolicy{})
+	s.Add(IndexerFlags{Recursive: true}, fieldpath)
+	s.Add(IndexerFlags{}, snippetpath)
+	s.Add(IndexerFlags{}, filepath.Join(htmlpath, "page.html"))
+	s.Wait()
+	assertSegmentSizes(t, s, []int{3, 1, 1})
+	assertSegmentedSearchResult(t, s, "the", []int{0, 1, 3})
+	assertSegmentedSearchResult(t, s, "quick", []int{3})
+	assertSegmentedSearchResult(t, s, "title:Guide", []int{4})
+	assertSegmentedSearchResult(t, s, "ext:txt", []int{1, 3})
+	// a prefix naming no field of any segment is part of the term
+	assertSegmentedSearchResult(t, s, "nofield:x", []int{})
+	if path, ok := s.Path(3); !ok || path != filepath.Join(snippetpath, "lorem.txt") {
+		t.Errorf("Unexpected path of document 3: %s", path)
+	}
+	if _, ok := s.Path(5); ok {
+		t.Error("Expected no document 5")
+	}
+	snippets, err := s.Snippets("lazy", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snippets) != 1 {
+		t.Errorf("Expected a snippet, actual: %v", snippets)
+	}
+}
+
+func TestSegmentedIndexMerges(t *testing.T) {
+	s := NewSegmentedIndex(MergePolicy{MergeFactor: 2, MinSegmentSize: 1})
+	paths := []string{
+		filepath.Join(fieldpath, "README.md"),
+		filepath.Join(fieldpath, "notes/my_notes.txt"),
+		filepath.Join(fieldpath, "src/lib.rs"),
+		filepath.Join(snippetpath, "lorem.txt"),
+		filepath.Join(htmlpath, "page.html"),
+	}
+	for _, path := range paths {
+		s.Add(IndexerFlags{}, path)
+	}
+	s.Wait()
+	// 5 documents end up in a segment of 4 and one of 1
+	assertSegmentSizes(t, s, []int{4, 1})
+	for docID, path := range paths {
+		if actual, _ := s.Path(docID); actual != path {
+			t.Errorf("Expected path of document %d: %s, actual: %s", docID, path, actual)
+		}
+	}
+	assertSegmentedSearchResult(t, s, "install", []int{0, 1})
+	assertSegmentedSearchResult(t, s, "\"lazy dog.\"", []int{3})
+	assertSegmentedSearchResult(t, s, "title:Guide", []int{4})
+	assertSegmentedSearchResult(t, s, "name:lib", []int{2})
+}
+
+func TestSegmentedIndexConcurrentSearch(t *testing.T) {
+	s := NewSegmentedIndex(MergePolicy{MergeFactor: 2, MinSegmentSize: 1})
+	var wg sync.WaitGroup
+	for k := 0; k < 4; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := s.Search("/i.*/"); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for k := 0; k < 8; k++ {
+		s.Add(IndexerFlags{}, filepath.Join(fieldpath, "README.md"))
+	}
+	wg.Wait()
+	s.Wait()
+	assertSegmentSizes(t, s, []int{8})
+	assertSegmentedSearchResult(t, s, "install", []int{0, 1, 2, 3, 4, 5, 6, 7})
+}
+
+func TestSegmentedIndexMergesWhileReadingDocuments(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	s := NewSegmentedIndex(MergePolicy{MergeFactor: 2, MinSegmentSize: 1})
+	done := make(chan bool)
+	go func() {
+		// documents of segments read from disk are decoded as they are
+		// looked up, which must not race with merging them
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				for docID := 0; docID < 8; docID++ {
+					s.Path(docID)
+				}
+			}
+		}
+	}()
+	for k := 0; k < 8; k++ {
+		s.Add(IndexerFlags{IndexDir: filepath.Join(dir, strconv.Itoa(k))},
+			filepath.Join(fieldpath, "README.md"))
+	}
+	s.Wait()
+	close(done)
+	assertSegmentSizes(t, s, []int{8})
+	for docID := 0; docID < 8; docID++ {
+		if path, _ := s.Path(docID); path != filepath.Join(fieldpath, "README.md") {
+			t.Errorf("Expected the path of document %d to be kept, actual: %s", docID, path)
+		}
+	}
+}
+
+func assertFindMerge(t *testing.T, policy MergePolicy, sizes []int, start, end int, ok bool) {
+	s, e, o := policy.findMerge(sizes)
+	if s != start || e != end || o != ok {
+		t.Errorf("Expected merge of %v: %d %d %v, actual: %d %d %v", sizes, start, end, ok, s, e, o)
+	}
+}
+
+func assertSegmentSizes(t *testing.T, s *SegmentedIndex, expected []int) {
+	if sizes := s.SegmentSizes(); !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("Expected segment sizes: %v, actual: %v", expected, sizes)
+	}
+}
+
+func assertSegmentedSearchResult(t *testing.T, s *SegmentedIndex, query string, expected []int) {
+	docIDs, err := s.Search(query)
+	if err != nil {
+		t.Errorf("query %q: %s", query, err)
+		return
+	}
+	if !reflect.DeepEqual(docIDs, expected) {
+		t.Errorf("query %q expected docIDs: %v, actual: %v", query, expected, docIDs)
+	}
+}
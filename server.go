@@ -0,0 +1,132 @@
+package invertedindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Server answers requests against an index over HTTP, in JSON:
+//
+//	GET /search?q=query   the documents matching query, with snippets of
+//	                      each if snippets=true is passed too
+//	GET /doc/{id}         the document with the passed docID and its text
+//	GET /stats            statistics of the index, listing as many of the
+//	                      most frequent terms as top=n asks for
+//
+// Errors are answered with an appropriate status and {"error": message}.
+// The index must not be modified while serving; requests are then safe to
+// handle concurrently.
+type Server struct {
+	indexer *Indexer
+	mux     *http.ServeMux
+}
+
+// SearchResult is the answer to a /search request
+type SearchResult struct {
+	Query string     `json:"query"`
+	Count int        `json:"count"`
+	Hits  []Document `json:"hits"`
+}
+
+// Document describes an indexed document, as answered by the server
+type Document struct {
+	ID   int    `json:"id"`
+	Path string `json:"path"`
+	// only set for documents read from JSON Lines files
+	ExternalID string            `json:"externalId,omitempty"`
+	Stored     map[string]string `json:"stored,omitempty"`
+	// only set by /search when snippets are requested
+	Snippets []Snippet `json:"snippets,omitempty"`
+	// only set by /doc
+	Text string `json:"text,omitempty"`
+}
+
+// NewServer returns a server answering requests against indexer, which must
+// have been built
+func NewServer(indexer *Indexer) *Server {
+	s := &Server{indexer: indexer, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/search", s.search)
+	s.mux.HandleFunc("/doc/", s.doc)
+	s.mux.HandleFunc("/stats", s.stats)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("q")
+	snippets := r.FormValue("snippets") == "true"
+	docIDs, err := s.indexer.Search(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	result := SearchResult{Query: query, Count: len(docIDs), Hits: []Document{}}
+	for _, docID := range docIDs {
+		hit := s.document(docID)
+		if snippets {
+			if hit.Snippets, err = s.indexer.Snippets(query, docID); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) doc(w http.ResponseWriter, r *http.Request) {
+	docID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/doc/"))
+	if _, ok := s.indexer.Path(docID); err != nil || !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no document %s",
+			strings.TrimPrefix(r.URL.Path, "/doc/")))
+		return
+	}
+	doc := s.document(docID)
+	text, _, err := s.indexer.documentText(docID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	doc.Text = string(text)
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	top := DefaultTopTerms
+	if r.FormValue("top") != "" {
+		var err error
+		if top, err = strconv.Atoi(r.FormValue("top")); err != nil || top < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid top: %s", r.FormValue("top")))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, s.indexer.Stats(top))
+}
+
+// document describes the document with the passed docID
+func (s *Server) document(docID int) Document {
+	path, _ := s.indexer.Path(docID)
+	externalID, _ := s.indexer.ExternalID(docID)
+	return Document{ID: docID, Path: path, ExternalID: externalID,
+		Stored: s.indexer.Stored(docID)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,111 @@
+package invertedindex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Tests for serving an index over HTTP
+
+func TestServeSearch(t *testing.T) {
+	server := NewServer(setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath))
+	var result SearchResult
+	assertServed(t, server, "/search?q=notes", http.StatusOK, &result)
+	if result.Query != "notes" || result.Count != 1 || len(result.Hits) != 1 {
+		t.Fatalf("Unexpected search result: %+v", result)
+	}
+	if hit := result.Hits[0]; hit.Path != filepath.Join(fieldpath, "notes/my_notes.txt") ||
+		hit.Snippets != nil {
+		t.Errorf("Unexpected hit: %+v", hit)
+	}
+}
+
+func TestServeSearchSnippets(t *testing.T) {
+	server := NewServer(setUpIndexer(t, IndexerFlags{}, snippetpath))
+	var result SearchResult
+	assertServed(t, server, "/search?q=quick&snippets=true", http.StatusOK, &result)
+	if result.Count != 1 || len(result.Hits[0].Snippets) == 0 {
+		t.Fatalf("Expected snippets, got: %+v", result)
+	}
+}
+
+func TestServeSearchWithoutMatches(t *testing.T) {
+	server := NewServer(setUpIndexer(t, IndexerFlags{}, snippetpath))
+	var result SearchResult
+	assertServed(t, server, "/search?q=absent", http.StatusOK, &result)
+	if result.Count != 0 || result.Hits == nil {
+		t.Errorf("Expected an empty list of hits, got: %+v", result)
+	}
+}
+
+func TestServeInvalidQuery(t *testing.T) {
+	server := NewServer(setUpIndexer(t, IndexerFlags{}, snippetpath))
+	var result map[string]string
+	assertServed(t, server, "/search?q=", http.StatusBadRequest, &result)
+	if result["error"] == "" {
+		t.Error("Expected an error message")
+	}
+}
+
+func TestServeDocument(t *testing.T) {
+	server := NewServer(setUpJSONLinesIndexer(t))
+	var doc Document
+	assertServed(t, server, "/doc/0", http.StatusOK, &doc)
+	if doc.ID != 0 || doc.ExternalID != "a1" || doc.Stored["title"] != "Installing Go" ||
+		doc.Text != "download the installer and run it" {
+		t.Errorf("Unexpected document: %+v", doc)
+	}
+	var result map[string]string
+	assertServed(t, server, "/doc/100", http.StatusNotFound, &result)
+	assertServed(t, server, "/doc/x", http.StatusNotFound, &result)
+}
+
+func TestServeStats(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath)
+	server := NewServer(indexer)
+	var stats Stats
+	assertServed(t, server, "/stats", http.StatusOK, &stats)
+	if stats.Documents != 3 || stats.Fields[bodyField].Terms != len(indexer.index) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestServeRejectsOtherMethods(t *testing.T) {
+	server := NewServer(setUpIndexer(t, IndexerFlags{}, snippetpath))
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("POST", "/search?q=quick", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, actual: %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+}
+
+func TestServeConcurrently(t *testing.T) {
+	server := NewServer(setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath))
+	var wg sync.WaitGroup
+	for k := 0; k < 20; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var result SearchResult
+			assertServed(t, server, "/search?q=/n.*s/", http.StatusOK, &result)
+		}()
+	}
+	wg.Wait()
+}
+
+// assertServed requests url from server, checks the status of the answer
+// and decodes it into v
+func assertServed(t *testing.T, server *Server, url string, status int, v interface{}) {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	if recorder.Code != status {
+		t.Errorf("%s: expected status %d, actual: %d", url, status, recorder.Code)
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(v); err != nil {
+		t.Errorf("%s: %s", url, err)
+	}
+}
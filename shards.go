@@ -0,0 +1,319 @@
+package invertedindex
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// shardDir returns the directory shard k of an index built on disk in dir
+// is written to
+func shardDir(dir string, k int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%03d", k))
+}
+
+// shardOf returns the shard out of n the file at path goes to
+func shardOf(path string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(n))
+}
+
+// inShard reports whether the file at path goes to the shard being built,
+// if the index is one
+func (i *Indexer) inShard(path string) bool {
+	return i.flags.Shards <= 1 || shardOf(path, i.flags.Shards) == i.flags.Shard
+}
+
+// ShardedIndex is an index whose documents are split across shards, each an
+// Indexer of its own, which are built and searched in parallel. A document
+// with docID d in shard k out of n has the docID d*n + k in the sharded
+// index. A ShardedIndex is safe for concurrent searches.
+type ShardedIndex struct {
+	shards []*Indexer
+}
+
+// BuildShardedIndex indexes the file or directory at path, as
+// Indexer.BuildIndex would with the passed flags, into n shards built in
+// parallel. Every shard crawls the whole of path but only reads its own
+// files. With the IndexDir flag each shard is built on disk in a directory
+// of its own within IndexDir, from which OpenShardedIndex opens them.
+func BuildShardedIndex(flags IndexerFlags, path string, n int) *ShardedIndex {
+	if n < 1 {
+		n = 1
+	}
+	s := &ShardedIndex{shards: make([]*Indexer, n)}
+	s.each(func(k int, shard *Indexer) {
+		shardFlags := flags
+		shardFlags.Shard, shardFlags.Shards = k, n
+		if flags.IndexDir != "" {
+			shardFlags.IndexDir = shardDir(flags.IndexDir, k)
+		}
+		s.shards[k] = new(Indexer)
+		s.shards[k].BuildIndex(shardFlags, path)
+	})
+	return s
+}
+
+// OpenShardedIndex opens the shards built on disk in dir by
+// BuildShardedIndex
+func OpenShardedIndex(dir string) (*ShardedIndex, error) {
+	s := new(ShardedIndex)
+	for k := 0; ; k++ {
+		if _, err := os.Stat(shardDir(dir, k)); os.IsNotExist(err) && k > 0 {
+			return s, nil
+		}
+		shard, err := OpenIndex(shardDir(dir, k))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards = append(s.shards, shard)
+	}
+}
+
+// Close closes the shards opened from disk
+func (s *ShardedIndex) Close() error {
+	var err error
+	for _, shard := range s.shards {
+		if shard == nil {
+			continue
+		}
+		if e := shard.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// each calls fn with every shard in parallel and waits for them all
+func (s *ShardedIndex) each(fn func(k int, shard *Indexer)) {
+	parallel(len(s.shards), func(k int) { fn(k, s.shards[k]) })
+}
+
+// parallel calls fn with every k in [0, n) in parallel and waits for them
+// all
+func parallel(n int, fn func(k int)) {
+	var wg sync.WaitGroup
+	for k := 0; k < n; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			fn(k)
+		}(k)
+	}
+	wg.Wait()
+}
+
+// docID returns the docID in the sharded index of a docID of shard k
+func (s *ShardedIndex) docID(k, docID int) int {
+	return docID*len(s.shards) + k
+}
+
+// shardOf returns the shard holding the document with the passed docID,
+// and its docID there
+func (s *ShardedIndex) shardOf(docID int) (*Indexer, int, bool) {
+	if docID < 0 {
+		return nil, 0, false
+	}
+	return s.shards[docID%len(s.shards)], docID / len(s.shards), true
+}
+
+// prepare parses query and looks up the fields its clauses search in every
+// shard, as prepareEach does
+func (s *ShardedIndex) prepare(query string) ([]fieldClause, [][]*field, error) {
+	return prepareEach(query, s.shards)
+}
+
+// prepareEach parses query and looks up the fields its clauses search in
+// each of the passed indexes; an index lacking one of them has nil fields,
+// as none of its documents can match. A field only needs to be known to one
+// of the indexes.
+func prepareEach(query string, indexes []*Indexer) ([]fieldClause, [][]*field, error) {
+	clauses, err := parseQuery(query, func(name string) bool {
+		for _, index := range indexes {
+			if index.hasField(name) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make([][]*field, len(indexes))
+	for k, index := range indexes {
+		fields[k] = make([]*field, len(clauses))
+		for j, c := range clauses {
+			if f, ok := index.fields[c.field]; ok {
+				fields[k][j] = f
+			} else {
+				fields[k] = nil
+				break
+			}
+		}
+	}
+	return clauses, fields, nil
+}
+
+// Search returns the sorted docIDs of the documents of every shard matching
+// query
+func (s *ShardedIndex) Search(query string) ([]int, error) {
+	clauses, fields, err := s.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([][]int, len(s.shards))
+	s.each(func(k int, shard *Indexer) {
+		if fields[k] != nil {
+			matches[k] = match(clauses, fields[k])
+		}
+	})
+	if err := s.diskError(); err != nil {
+		return nil, err
+	}
+	docIDs := []int{}
+	for k := range matches {
+		for _, docID := range matches[k] {
+			docIDs = append(docIDs, s.docID(k, docID))
+		}
+	}
+	sort.Ints(docIDs)
+	return docIDs, nil
+}
+
+// RankedSearch returns the documents of every shard matching query ordered
+// by score, as Indexer.RankedSearch does. Scores use the statistics of the
+// whole index, gathered from every shard before any scores, so they do not
+// depend on how documents are split.
+func (s *ShardedIndex) RankedSearch(query string) ([]Hit, error) {
+	clauses, fields, err := s.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	stats := s.collectionStats(clauses, fields)
+	ranked := make([][]Hit, len(s.shards))
+	s.each(func(k int, shard *Indexer) {
+		if fields[k] != nil {
+			ranked[k] = rank(clauses, fields[k], match(clauses, fields[k]), stats)
+		}
+	})
+	if err := s.diskError(); err != nil {
+		return nil, err
+	}
+	hits := []Hit{}
+	for k := range ranked {
+		for _, hit := range ranked[k] {
+			hits = append(hits, Hit{DocID: s.docID(k, hit.DocID), Score: hit.Score})
+		}
+	}
+	sort.Sort(byScore(hits))
+	return hits, nil
+}
+
+// collectionStats gathers the statistics of the terms the clauses match
+// from every shard
+func (s *ShardedIndex) collectionStats(clauses []fieldClause, fields [][]*field) collectionStats {
+	local := make([]collectionStats, len(s.shards))
+	s.each(func(k int, shard *Indexer) {
+		local[k] = newCollectionStats()
+		if fields[k] != nil {
+			local[k] = queryStats(clauses, fields[k])
+		}
+		local[k].documents = shard.documentCount()
+	})
+	stats := newCollectionStats()
+	for _, l := range local {
+		stats.add(l)
+	}
+	return stats
+}
+
+// Explain breaks down the score RankedSearch gives the document with the
+// passed docID for query, as Indexer.Explain does
+func (s *ShardedIndex) Explain(query string, docID int) (Explanation, error) {
+	shard, local, ok := s.shardOf(docID)
+	if ok {
+		_, ok = shard.Path(local)
+	}
+	if !ok {
+		return Explanation{}, fmt.Errorf("no document with docID %d", docID)
+	}
+	clauses, fields, err := s.prepare(query)
+	if err != nil {
+		return Explanation{}, err
+	}
+	k := docID % len(s.shards)
+	if fields[k] == nil {
+		// the shard lacks a field the query searches
+		return Explanation{DocID: docID, Clauses: []ClauseExplanation{}}, nil
+	}
+	e := explainDocument(clauses, fields[k], local, s.collectionStats(clauses, fields))
+	if err := s.diskError(); err != nil {
+		return Explanation{}, err
+	}
+	e.DocID = docID
+	return e, nil
+}
+
+// diskError returns the first error reading the files of a shard met
+func (s *ShardedIndex) diskError() error {
+	for _, shard := range s.shards {
+		if err := shard.diskError(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Path returns the path of the document with the passed docID, and whether
+// such a document was indexed
+func (s *ShardedIndex) Path(docID int) (string, bool) {
+	shard, local, ok := s.shardOf(docID)
+	if !ok {
+		return "", false
+	}
+	return shard.Path(local)
+}
+
+// Stored returns the stored field values of the document with the passed
+// docID, as Indexer.Stored does
+func (s *ShardedIndex) Stored(docID int) map[string]string {
+	shard, local, ok := s.shardOf(docID)
+	if !ok {
+		return nil
+	}
+	return shard.Stored(local)
+}
+
+// Snippets returns the snippets of the document with the passed docID
+// matching query, as Indexer.Snippets does
+func (s *ShardedIndex) Snippets(query string, docID int) ([]Snippet, error) {
+	shard, local, ok := s.shardOf(docID)
+	if !ok {
+		shard, local = new(Indexer), docID
+	}
+	return shard.Snippets(query, local)
+}
+
+// Report returns the reports of the crawls of every shard combined
+func (s *ShardedIndex) Report() CrawlReport {
+	report := CrawlReport{Skipped: []SkippedFile{}}
+	seen := make(map[SkippedFile]bool)
+	for _, shard := range s.shards {
+		r := shard.Report()
+		report.Indexed += r.Indexed
+		// files skipped before they were assigned a shard are skipped by
+		// every shard
+		for _, skipped := range r.Skipped {
+			if !seen[skipped] {
+				seen[skipped] = true
+				report.Skipped = append(report.Skipped, skipped)
+			}
+		}
+	}
+	return report
+}
@@ -0,0 +1,102 @@
+package invertedindex
+
+import (
+	"math"
+	"os"
+	"sort"
+	"testing"
+)
+
+// Tests for splitting an index into shards searched in parallel
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, 4)
+	for _, path := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		k := shardOf(path, 4)
+		if k != shardOf(path, 4) || k < 0 || k >= 4 {
+			t.Fatalf("Unexpected shard of %s: %d", path, k)
+		}
+		counts[k]++
+	}
+	for k, n := range counts {
+		if n == 0 {
+			t.Errorf("Expected shard %d to get a file, got: %v", k, counts)
+		}
+	}
+}
+
+func TestShardedSearch(t *testing.T) {
+	flags := IndexerFlags{Recursive: true}
+	whole := setUpIndexer(t, flags, indexpath)
+	sharded := BuildShardedIndex(flags, indexpath, 3)
+	if report := sharded.Report(); report.Indexed != 6 {
+		t.Errorf("Expected 6 documents across the shards, actual: %d", report.Indexed)
+	}
+	for _, shard := range sharded.shards {
+		if shard.documentCount() == 0 {
+			t.Error("Expected every shard to hold a document")
+		}
+	}
+	for _, query := range []string{"alpha", "beta gamma", "\"alpha beta\"", "name:a", "gamm~1", "missing"} {
+		assertSameShardedResults(t, sharded, whole, query)
+	}
+	assertSameShardedResults(t, sharded, whole, "nofield:alpha")
+	if _, ok := sharded.Path(-1); ok {
+		t.Error("Expected no document with a negative docID")
+	}
+}
+
+func TestShardedIndexOnDisk(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	flags := IndexerFlags{Recursive: true}
+	whole := setUpIndexer(t, flags, indexpath)
+	flags.IndexDir = dir
+	BuildShardedIndex(flags, indexpath, 2).Close()
+	opened, err := OpenShardedIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	if len(opened.shards) != 2 {
+		t.Errorf("Expected 2 shards, actual: %d", len(opened.shards))
+	}
+	assertSameShardedResults(t, opened, whole, "alpha gamma")
+	if _, err := OpenShardedIndex("test_files/no_such_index"); err == nil {
+		t.Error("Expected an error opening a missing index")
+	}
+}
+
+// assertSameShardedResults checks that a sharded index finds the same
+// documents as the whole index does, with the same scores
+func assertSameShardedResults(t *testing.T, sharded *ShardedIndex, whole *Indexer, query string) {
+	expected, err := whole.RankedSearch(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := sharded.RankedSearch(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[string]float64)
+	for _, hit := range expected {
+		path, _ := whole.Path(hit.DocID)
+		scores[path] = hit.Score
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("Expected %d hits for %q, actual: %v", len(expected), query, actual)
+	}
+	for _, hit := range actual {
+		path, ok := sharded.Path(hit.DocID)
+		if score, found := scores[path]; !ok || !found || math.Abs(score-hit.Score) > 1e-9 {
+			t.Errorf("Unexpected hit for %q: %s %v", query, path, hit)
+		}
+	}
+	docIDs, err := sharded.Search(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.IntsAreSorted(docIDs) || len(docIDs) != len(expected) {
+		t.Errorf("Expected %d sorted docIDs for %q, actual: %v", len(expected), query, docIDs)
+	}
+}
@@ -0,0 +1,217 @@
+package invertedindex
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// snippetContext is the number of bytes of surrounding text shown on either
+// side of a match in a snippet
+const snippetContext = 40
+
+// span is a byte range [start, end) of a document, along with the line and
+// column it starts at
+type span struct {
+	start, end   int
+	line, column int
+}
+
+// A Snippet is a short window of a document's original text around one or
+// more matches of a query. Matches that are close together share a snippet.
+type Snippet struct {
+	// Offset is the byte offset of Text in the document
+	Offset int    `json:"offset"`
+	Text   string `json:"text"`
+	// Matches are the byte ranges [start, end) of the matches within Text
+	Matches [][2]int `json:"matches"`
+}
+
+// Highlight returns the text of the snippet with every match surrounded by
+// the passed markers
+func (s Snippet) Highlight(before, after string) string {
+	var buf bytes.Buffer
+	last := 0
+	for _, m := range s.Matches {
+		buf.WriteString(s.Text[last:m[0]])
+		buf.WriteString(before)
+		buf.WriteString(s.Text[m[0]:m[1]])
+		buf.WriteString(after)
+		last = m[1]
+	}
+	buf.WriteString(s.Text[last:])
+	return buf.String()
+}
+
+// Snippets returns the snippets of the document with the passed docID that
+// show where query matched it, in document order. The text is re-read from
+// the document's file, so snippets of files changed since indexing may be
+// off.
+func (i *Indexer) Snippets(query string, docID int) ([]Snippet, error) {
+	contents, spans, _, err := i.documentMatches(query, docID)
+	if err != nil {
+		return nil, err
+	}
+	return makeSnippets(contents, spans), nil
+}
+
+// documentMatches re-reads the document with the passed docID and returns
+// its contents along with the byte ranges every clause of query matched.
+// Only clauses searching the body are located, since the contents are what
+// the body was indexed from. The markup of an HTML document is returned too,
+// see documentText.
+func (i *Indexer) documentMatches(query string, docID int) ([]byte, []span, *markup, error) {
+	clauses, err := parseQuery(query, i.hasField)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	contents, source, err := i.documentText(docID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	spans := []span{}
+	for _, c := range clauses {
+		if c.field == bodyField {
+			spans = append(spans, c.matches(i.fields[bodyField], docID)...)
+		}
+	}
+	if err := i.diskError(); err != nil {
+		return nil, nil, nil, err
+	}
+	return contents, spans, source, nil
+}
+
+// markup is the HTML a document's text was extracted from, along with where
+// in it every part of the text came from
+type markup struct {
+	contents []byte
+	offsets  sourceMap
+}
+
+// documentText returns the text the body of the document with the passed
+// docID was indexed from: the text extracted from its file, or for a record
+// of a JSON Lines file the stored value of its "body" key. The markup is
+// only returned for an HTML document, whose text is not its file's.
+func (i *Indexer) documentText(docID int) ([]byte, *markup, error) {
+	record, ok := i.record(docID)
+	if !ok {
+		return nil, nil, fmt.Errorf("no document with docID %d", docID)
+	}
+	path := record.Path
+	if record.ExternalID != nil {
+		body, ok := record.Stored[bodyField]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: the body key is not stored", path)
+		}
+		return []byte(body), nil, nil
+	}
+	contents, err := readDocumentFile(path)
+	if err == nil {
+		contents, err = decodeText(contents)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if isHTML(path, contents) {
+		fields, offsets := extractHTMLOffsets(contents)
+		return fields[bodyField], &markup{contents, offsets}, nil
+	}
+	return extractorFor(path, contents)(contents)[bodyField], nil, nil
+}
+
+// locate returns where in the markup the passed spans of its text are,
+// with their lines and columns counted in the markup
+func (m *markup) locate(spans []span) []span {
+	located := make([]span, 0, len(spans))
+	for _, s := range spans {
+		if s.start >= s.end {
+			continue
+		}
+		start, end := m.offsets.toSource(s.start, false), m.offsets.toSource(s.end, true)
+		if start >= end || end > len(m.contents) {
+			continue
+		}
+		lineStart := bytes.LastIndexByte(m.contents[:start], '\n') + 1
+		located = append(located, span{start: start, end: end,
+			line: bytes.Count(m.contents[:start], []byte("\n")) + 1, column: start - lineStart + 1})
+	}
+	return located
+}
+
+// makeSnippets cuts windows of text around the passed matches. Overlapping
+// matches are merged and matches whose windows overlap share a snippet.
+func makeSnippets(text []byte, matches []span) []Snippet {
+	spans := mergeSpans(text, matches)
+	snippets := []Snippet{}
+	for k := 0; k < len(spans); {
+		start := snippetStart(text, spans[k].start)
+		end := snippetEnd(text, spans[k].end)
+		first := k
+		for k++; k < len(spans) && snippetStart(text, spans[k].start) < end; k++ {
+			end = snippetEnd(text, spans[k].end)
+		}
+		s := Snippet{Offset: start, Text: string(text[start:end])}
+		for _, m := range spans[first:k] {
+			s.Matches = append(s.Matches, [2]int{m.start - start, m.end - start})
+		}
+		snippets = append(snippets, s)
+	}
+	return snippets
+}
+
+// mergeSpans sorts spans, drops those that fall outside text and merges
+// those that overlap
+func mergeSpans(text []byte, spans []span) []span {
+	sort.Slice(spans, func(a, b int) bool { return spans[a].start < spans[b].start })
+	merged := []span{}
+	for _, s := range spans {
+		if s.start < 0 || s.end > len(text) || s.start >= s.end {
+			continue
+		}
+		if n := len(merged); n > 0 && s.start < merged[n-1].end {
+			if s.end > merged[n-1].end {
+				merged[n-1].end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// snippetStart returns where a snippet showing a match starting at the
+// passed offset begins: up to snippetContext bytes earlier, moved forward to
+// the start of a word so that words are not cut in half
+func snippetStart(text []byte, match int) int {
+	start := match - snippetContext
+	if start <= 0 {
+		return 0
+	}
+	if k := bytes.IndexFunc(text[start:match], unicode.IsSpace); k >= 0 {
+		_, size := utf8.DecodeRune(text[start+k:])
+		return start + k + size
+	}
+	for start < match && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	return start
+}
+
+// snippetEnd returns where a snippet showing a match ending at the passed
+// offset ends: up to snippetContext bytes later, moved back to the end of a
+// word so that words are not cut in half
+func snippetEnd(text []byte, match int) int {
+	end := match + snippetContext
+	if end >= len(text) {
+		return len(text)
+	}
+	if k := bytes.LastIndexFunc(text[match:end], unicode.IsSpace); k >= 0 {
+		return match + k
+	}
+	for end > match && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return end
+}
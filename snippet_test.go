@@ -0,0 +1,90 @@
+package invertedindex
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for cutting snippets of matches out of the original text
+
+var snippetpath string = "test_files/snippet_files"
+
+func assertHighlightedSnippets(t *testing.T, snippets []Snippet, expected []string) {
+	actual := make([]string, len(snippets))
+	for i, s := range snippets {
+		actual[i] = s.Highlight("[", "]")
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected snippets: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestSnippetWholeTextWithinContext(t *testing.T) {
+	text := []byte("alpha beta gamma")
+	snippets := makeSnippets(text, []span{{start: 6, end: 10}})
+	assertHighlightedSnippets(t, snippets, []string{"alpha [beta] gamma"})
+}
+
+func TestSnippetCutsAtWordBoundaries(t *testing.T) {
+	text := []byte("one two three four five six seven eight nine ten eleven twelve " +
+		"thirteen fourteen fifteen sixteen seventeen eighteen nineteen twenty")
+	start := len("one two three four five six seven eight nine ten eleven ")
+	snippets := makeSnippets(text, []span{{start: start, end: start + len("twelve")}})
+	assertHighlightedSnippets(t, snippets, []string{
+		"five six seven eight nine ten eleven [twelve] thirteen fourteen fifteen sixteen"})
+	if snippets[0].Offset != len("one two three four ") {
+		t.Errorf("Expected snippet offset: %d, actual: %d", len("one two three four "), snippets[0].Offset)
+	}
+}
+
+func TestSnippetMergesOverlappingMatches(t *testing.T) {
+	text := []byte("alpha beta gamma")
+	snippets := makeSnippets(text, []span{{start: 6, end: 16}, {start: 0, end: 5}, {start: 6, end: 10}})
+	assertHighlightedSnippets(t, snippets, []string{"[alpha] [beta gamma]"})
+}
+
+func TestSnippetSeparatesDistantMatches(t *testing.T) {
+	filler := " filler filler filler filler filler filler filler filler filler filler "
+	text := []byte("alpha" + filler + "beta")
+	snippets := makeSnippets(text, []span{{start: 0, end: 5}, {start: len(text) - 4, end: len(text)}})
+	if len(snippets) != 2 {
+		t.Fatalf("Expected number of snippets: 2, actual: %d", len(snippets))
+	}
+	if snippets[0].Matches[0] != [2]int{0, 5} || snippets[1].Matches[0] != [2]int{len(snippets[1].Text) - 4, len(snippets[1].Text)} {
+		t.Errorf("Improper match ranges in snippets: %v", snippets)
+	}
+}
+
+func TestSnippetDropsMatchesOutsideText(t *testing.T) {
+	snippets := makeSnippets([]byte("alpha"), []span{{start: 3, end: 9}})
+	assertHighlightedSnippets(t, snippets, []string{})
+}
+
+func TestSnippetsOfTerms(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(snippetpath, "lorem.txt"))
+	snippets, err := indexer.Snippets("liquor", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{
+		"lazy dog. Pack my box with\nfive dozen [liquor] jugs. How vexingly quick daft zebras"})
+}
+
+func TestSnippetsOfPhrase(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(snippetpath, "lorem.txt"))
+	snippets, err := indexer.Snippets(`"quick brown" sleeps.`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{
+		"The [quick brown] fox jumps over the lazy dog. Pack my",
+		"boxing wizards jump quickly, and the [quick brown] fox\n[sleeps.]\n"})
+}
+
+func TestSnippetsUnknownDocument(t *testing.T) {
+	indexer := setUpIndexer(t, IndexerFlags{}, filepath.Join(snippetpath, "lorem.txt"))
+	if _, err := indexer.Snippets("jugs", 7); err == nil {
+		t.Error("expected error for snippets of unknown document")
+	}
+}
@@ -0,0 +1,309 @@
+package invertedindex
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultMemoryBudget is how many bytes the postings held in memory may
+// take while building an index on disk, unless the MemoryBudget flag says
+// otherwise
+const DefaultMemoryBudget = 64 << 20
+
+// runsDir is the directory of the index directory that runs are written to
+// while the index is being built
+const runsDir = "runs"
+
+// memoryUsed estimates the memory taken by the postings of every field
+func (i *Indexer) memoryUsed() int64 {
+	var used int64
+	for _, f := range i.fields {
+		used += f.size
+	}
+	return used
+}
+
+// flushIfOverBudget writes the postings held in memory out as a run when the
+// index is being built on disk and they take more than the memory budget
+func (i *Indexer) flushIfOverBudget() {
+	if i.flags.IndexDir == "" {
+		return
+	}
+	budget := i.flags.MemoryBudget
+	if budget <= 0 {
+		budget = DefaultMemoryBudget
+	}
+	if i.memoryUsed() > budget {
+		i.flushRun()
+	}
+}
+
+// flushRun writes the postings held in memory to a new run, then empties
+// them. As documents are added in docID order, the postings of a term in a
+// run all follow its postings in earlier runs.
+func (i *Indexer) flushRun() {
+	path := filepath.Join(i.flags.IndexDir, runsDir, fmt.Sprintf("run-%06d", len(i.runs)))
+	if i.flags.Verbose {
+		fmt.Printf("Writing run: %s\n", path)
+	}
+	if err := i.writeRun(path); err != nil {
+		fmt.Println(err)
+		i.cleanup()
+		os.Exit(1)
+	}
+	i.runs = append(i.runs, path)
+	for _, f := range i.fields {
+		f.reset()
+	}
+	i.index = i.fields[bodyField].postings
+}
+
+// writeRun writes the postings held in memory to a run file at path. A run
+// holds an entry for every term of every field, sorted by field and then
+// term; each entry is the field, the term and the encoded postings, all
+// preceded by their lengths.
+func (i *Indexer) writeRun(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if _, err := w.WriteString(runMagic); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(i.fields))
+	for name := range i.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		f := i.fields[name]
+		for _, term := range f.dictionary() {
+			buf = encodePostings(buf[:0], f.postings[term], f.positions[term])
+			if err := writeRunEntry(w, runEntry{field: name, term: term, postings: buf}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// runEntry is an entry of a run
+type runEntry struct {
+	field, term string
+	postings    []byte
+}
+
+func writeRunEntry(w *bufio.Writer, e runEntry) error {
+	if err := writeString(w, e.field); err != nil {
+		return err
+	}
+	if err := writeString(w, e.term); err != nil {
+		return err
+	}
+	return writeString(w, string(e.postings))
+}
+
+// runReader reads the entries of a run in order
+type runReader struct {
+	file *os.File
+	r    *bufio.Reader
+	// the current entry, and the position of the run among those merged
+	entry runEntry
+	index int
+}
+
+func openRun(path string, index int) (*runReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &runReader{file: file, r: bufio.NewReader(file), index: index}
+	if err := readMagic(r.r, runMagic); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return r, nil
+}
+
+// next reads the next entry of the run, returning io.EOF after the last
+func (r *runReader) next() error {
+	var err error
+	if r.entry.field, err = readString(r.r); err != nil {
+		return err
+	}
+	if r.entry.term, err = readString(r.r); err != nil {
+		return unexpectedEOF(err)
+	}
+	postings, err := readString(r.r)
+	r.entry.postings = []byte(postings)
+	return unexpectedEOF(err)
+}
+
+// runHeap orders runs by their current entry, and runs with equal entries
+// by the order they were written in
+type runHeap []*runReader
+
+func (h runHeap) Len() int      { return len(h) }
+func (h runHeap) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+func (h runHeap) Less(a, b int) bool {
+	x, y := h[a].entry, h[b].entry
+	if x.field != y.field {
+		return x.field < y.field
+	}
+	if x.term != y.term {
+		return x.term < y.term
+	}
+	return h[a].index < h[b].index
+}
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the runs at the passed paths, in the order they were
+// written, into the terms, terms.idx and postings files of a commit. The
+// postings of a term found in several runs are concatenated.
+func mergeRuns(paths []string, c *commitWriter) error {
+	h := runHeap{}
+	defer func() {
+		for _, r := range h {
+			r.file.Close()
+		}
+	}()
+	for k, path := range paths {
+		r, err := openRun(path, k)
+		if err != nil {
+			return err
+		}
+		if err := r.next(); err == io.EOF {
+			r.file.Close()
+			continue
+		} else if err != nil {
+			r.file.Close()
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	tw, err := c.create(termsFile)
+	if err != nil {
+		return err
+	}
+	xw, err := c.create(termIndexFile)
+	if err != nil {
+		return err
+	}
+	pw, err := c.create(postingsFile)
+	if err != nil {
+		return err
+	}
+	if _, err := tw.WriteString(termsMagic); err != nil {
+		return err
+	}
+	if _, err := xw.WriteString(termIndexMagic); err != nil {
+		return err
+	}
+	if _, err := pw.WriteString(postingsMagic); err != nil {
+		return err
+	}
+	offset, termOffset := uint64(len(postingsMagic)), uint64(len(termsMagic))
+	var entry []byte
+	var scratch [8]byte
+
+	for len(h) > 0 {
+		field, term := h[0].entry.field, h[0].entry.term
+		var parts []runEntry
+		var sources []string
+		for len(h) > 0 && h[0].entry.field == field && h[0].entry.term == term {
+			r := h[0]
+			parts = append(parts, r.entry)
+			sources = append(sources, paths[r.index])
+			if err := r.next(); err == io.EOF {
+				r.file.Close()
+				heap.Pop(&h)
+			} else if err != nil {
+				return fmt.Errorf("%s: %s", paths[r.index], err)
+			} else {
+				heap.Fix(&h, 0)
+			}
+		}
+		data, err := concatPostings(parts, sources)
+		if err != nil {
+			return err
+		}
+		n, err := countDocuments(data)
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write(data); err != nil {
+			return err
+		}
+		e := termEntry{field: field, term: term, offset: offset, length: uint64(len(data)),
+			documentFrequency: n}
+		entry = appendTermEntry(entry[:0], e)
+		if _, err := tw.Write(entry); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(scratch[:], termOffset)
+		if _, err := xw.Write(scratch[:]); err != nil {
+			return err
+		}
+		offset += uint64(len(data))
+		termOffset += uint64(len(entry))
+	}
+
+	for _, w := range []*checksumWriter{tw, xw, pw} {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// concatPostings returns the postings of a term from each of the passed run
+// entries as one posting list. The entries must be in the order their runs
+// were written; sources names the runs for errors.
+func concatPostings(parts []runEntry, sources []string) ([]byte, error) {
+	if len(parts) == 1 {
+		return parts[0].postings, nil
+	}
+	var docIDs []int
+	var occurrences map[int][]occurrence
+	for k, part := range parts {
+		moreIDs, more, err := decodePostings(part.postings)
+		if err != nil {
+			return nil, fmt.Errorf("%s: postings of %s:%s: %s", sources[k], part.field, part.term, err)
+		}
+		docIDs = append(docIDs, moreIDs...)
+		for docID, list := range more {
+			if occurrences == nil {
+				occurrences = make(map[int][]occurrence)
+			}
+			occurrences[docID] = list
+		}
+	}
+	return encodePostings(nil, docIDs, occurrences), nil
+}
+
+// countDocuments returns the number of documents of encoded postings
+func countDocuments(postings []byte) (uint64, error) {
+	d := decoder{data: postings}
+	n := d.uvarint()
+	return n, d.err
+}
@@ -0,0 +1,226 @@
+package invertedindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests for building an index on disk from sorted runs
+
+func TestEncodePostings(t *testing.T) {
+	docIDs := []int{3, 7, 100000}
+	occurrences := map[int][]occurrence{
+		3:      {{position: 0, offset: 0, length: 5, line: 1, column: 1}, {position: 4, offset: 20, length: 3, line: 2, column: 7}},
+		7:      {{position: 2, offset: 9, length: 1, line: 1, column: 10}},
+		100000: {{position: 300, offset: 5000, length: 12, line: 40, column: 1}},
+	}
+	decodedIDs, decoded, err := decodePostings(encodePostings(nil, docIDs, occurrences))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedIDs, docIDs) || !reflect.DeepEqual(decoded, occurrences) {
+		t.Errorf("Expected postings: %v %v, actual: %v %v", docIDs, occurrences, decodedIDs, decoded)
+	}
+}
+
+func TestEncodePostingsWithoutPositions(t *testing.T) {
+	decodedIDs, decoded, err := decodePostings(encodePostings(nil, []int{0, 1, 5}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedIDs, []int{0, 1, 5}) || decoded != nil {
+		t.Errorf("Unexpected postings: %v %v", decodedIDs, decoded)
+	}
+}
+
+func TestDecodeCorruptPostings(t *testing.T) {
+	data := encodePostings(nil, []int{1, 2}, nil)
+	for _, corrupt := range [][]byte{data[:len(data)-1], append(data, 0), {0xff}, {2, 1, 0, 0, 0}} {
+		if _, _, err := decodePostings(corrupt); err == nil {
+			t.Errorf("Expected an error decoding %v", corrupt)
+		}
+	}
+}
+
+func TestBuildIndexOnDisk(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	inMemory := setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath)
+	// a budget of one byte writes a run for every document
+	onDisk := setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir, MemoryBudget: 1}, fieldpath)
+	assertSameIndex(t, onDisk, inMemory)
+	assertSearchResult(t, onDisk, "install", []int{0, 1})
+	assertSearchResult(t, onDisk, "ext:txt", []int{1})
+	if _, err := os.Stat(filepath.Join(dir, runsDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected the runs to be removed, got: %v", err)
+	}
+
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameIndex(t, opened, inMemory)
+}
+
+func TestBuildIndexOnDiskWithinBudget(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	inMemory := setUpIndexer(t, IndexerFlags{}, snippetpath)
+	onDisk := setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+	assertSameIndex(t, onDisk, inMemory)
+	snippets, err := onDisk.Snippets("lazy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHighlightedSnippets(t, snippets, []string{"The quick brown fox jumps over the [lazy] dog. Pack my box with\nfive dozen"})
+}
+
+func TestBuildIndexOnDiskReadsInPlace(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	onDisk := setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	defer onDisk.Close()
+	// the index built is the commit on disk, not a copy decoded into memory
+	if onDisk.disk == nil {
+		t.Fatal("Expected the index built to be read from disk")
+	}
+	for name, f := range onDisk.fields {
+		if len(f.postings) != 0 || f.complete {
+			t.Errorf("Expected nothing of the %s field to be decoded on building", name)
+		}
+	}
+	if len(onDisk.documents) != 0 {
+		t.Errorf("Expected no documents to be decoded on building, actual: %v", onDisk.documents)
+	}
+	assertSearchResult(t, onDisk, "install", []int{0, 1})
+}
+
+func TestBuildJSONLinesIndexOnDisk(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	config, err := LoadJSONLinesConfig(filepath.Join(jsonlpath, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := filepath.Join(jsonlpath, "records.jsonl")
+	inMemory := setUpIndexer(t, IndexerFlags{JSONLines: config}, records)
+	onDisk := setUpIndexer(t, IndexerFlags{JSONLines: config, IndexDir: dir, MemoryBudget: 1}, records)
+	assertSameIndex(t, onDisk, inMemory)
+	if !reflect.DeepEqual(onDisk.stored, inMemory.stored) ||
+		!reflect.DeepEqual(onDisk.externalIDs, inMemory.externalIDs) {
+		t.Error("Expected the stored values and external IDs to be kept")
+	}
+}
+
+func TestOpenIndexLazily(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	body := opened.fields[bodyField]
+	if len(body.postings) != 0 || len(opened.documents) != 0 {
+		t.Error("Expected nothing to be decoded on opening")
+	}
+	assertSearchResult(t, opened, "install tool", []int{0, 1})
+	assertSearchResult(t, opened, "missing", []int{})
+	for _, term := range []string{"install", "tool", "missing"} {
+		if _, ok := opened.postingCache.get(postingKey{body, term}); !ok {
+			t.Errorf("Expected the postings of %q to be cached", term)
+		}
+	}
+	if n := opened.postingCache.len(); n != 3 {
+		t.Errorf("Expected only the 3 terms searched to be decoded, actual: %d", n)
+	}
+	if len(body.postings) != 0 {
+		t.Errorf("Expected the postings to be left to the cache, actual: %v", body.postings)
+	}
+	if path, ok := opened.Path(2); !ok || filepath.Base(path) != "lib.rs" {
+		t.Errorf("Expected document 2 to be lib.rs, actual: %s %v", path, ok)
+	}
+	if len(opened.documents) != 1 {
+		t.Errorf("Expected one document to be read, actual: %v", opened.documents)
+	}
+	if _, ok := opened.Path(3); ok {
+		t.Error("Expected no document 3")
+	}
+	if report := opened.Report(); report.Indexed != 3 {
+		t.Errorf("Expected 3 documents, actual: %d", report.Indexed)
+	}
+	assertEqualTerms(t, opened.fields[extField].dictionary(), []string{"md", "rs", "txt"})
+}
+
+func TestOpenCorruptIndex(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{IndexDir: dir}, snippetpath)
+	for _, name := range []string{termsFile, termIndexFile, postingsFile, docIndexFile} {
+		path := filepath.Join(generationDir(dir, 1), name)
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, contents[1:], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenIndex(dir); err == nil {
+			t.Errorf("Expected an error opening an index with a corrupt %s file", name)
+		}
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opened.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpenMissingIndex(t *testing.T) {
+	if _, err := OpenIndex("test_files/no_such_index"); err == nil {
+		t.Error("Expected an error opening a missing index")
+	}
+}
+
+func tempIndexDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "invertedindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// assertSameIndex checks that two indexes have the same documents and the
+// same postings and positions in every field
+func assertSameIndex(t *testing.T, actual, expected *Indexer) {
+	actual.loadAll()
+	assertEqualDocumentMapping(t, actual.documents, expected.documents)
+	if len(actual.fields) != len(expected.fields) {
+		t.Errorf("Expected %d fields, actual: %d", len(expected.fields), len(actual.fields))
+	}
+	for name, f := range expected.fields {
+		g, ok := actual.fields[name]
+		if !ok {
+			t.Errorf("Expected field %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(g.postings, f.postings) {
+			t.Errorf("Expected postings of %s: %v, actual: %v", name, f.postings, g.postings)
+		}
+		if !reflect.DeepEqual(g.positions, f.positions) {
+			t.Errorf("Expected positions of %s: %v, actual: %v", name, f.positions, g.positions)
+		}
+		if g.foldCase != f.foldCase {
+			t.Errorf("Expected field %s to fold case: %v", name, f.foldCase)
+		}
+	}
+}
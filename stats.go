@@ -0,0 +1,238 @@
+package invertedindex
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultTopTerms is how many of the most frequent terms statistics list
+// unless told otherwise
+const DefaultTopTerms = 20
+
+// Stats summarizes the contents of an index. Apart from Documents and
+// Fields, the statistics are of the body field.
+type Stats struct {
+	// number of documents indexed
+	Documents int `json:"documents"`
+	// number of distinct terms
+	Terms int `json:"terms"`
+	// number of (term, document) pairs, i.e. the summed lengths of the
+	// posting lists
+	Postings int `json:"postings"`
+	// number of term occurrences
+	Tokens int `json:"tokens"`
+	// how many posting lists have lengths within each bucket, in increasing
+	// order of length
+	PostingLengths []LengthBucket `json:"postingLengths"`
+	// terms occurring in the most documents, most first
+	TopTerms []TermStats `json:"topTerms"`
+	// fit of Heaps' law, terms = K * tokens^beta, over the vocabulary size
+	// after each document; nil if there are too few documents to fit
+	Heaps *PowerLawFit `json:"heaps,omitempty"`
+	// fit of Zipf's law, frequency = C * rank^-s, over the collection
+	// frequency of every term by rank; nil if there are too few terms to fit
+	Zipf *PowerLawFit `json:"zipf,omitempty"`
+	// field name -> statistics of that field
+	Fields map[string]FieldStats `json:"fields"`
+	// hits and misses of the caches of the index
+	Cache CacheStats `json:"cache"`
+}
+
+// FieldStats summarizes the postings of one field
+type FieldStats struct {
+	Terms    int `json:"terms"`
+	Postings int `json:"postings"`
+}
+
+// LengthBucket counts the posting lists with lengths in [Min, Max]
+type LengthBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Terms int `json:"terms"`
+}
+
+// TermStats gives the number of documents a term occurs in and the number of
+// times it occurs overall
+type TermStats struct {
+	Term                string `json:"term"`
+	DocumentFrequency   int    `json:"documentFrequency"`
+	CollectionFrequency int    `json:"collectionFrequency"`
+}
+
+// PowerLawFit is a least squares fit of y = Coefficient * x^Exponent on a
+// log-log scale. For Zipf's law, Exponent is s rather than -s. R2 is the
+// coefficient of determination of the fit.
+type PowerLawFit struct {
+	Coefficient float64 `json:"coefficient"`
+	Exponent    float64 `json:"exponent"`
+	R2          float64 `json:"r2"`
+}
+
+// Stats returns statistics of the index, listing the top most frequent
+// terms, or none if top is negative
+func (i *Indexer) Stats(top int) Stats {
+	i.loadAll()
+	body := i.fields[bodyField]
+	stats := Stats{Documents: i.documentCount(), Terms: len(body.postings),
+		PostingLengths: []LengthBucket{}, Fields: make(map[string]FieldStats)}
+	for name, f := range i.fields {
+		fieldStats := FieldStats{Terms: len(f.postings)}
+		for _, docIDs := range f.postings {
+			fieldStats.Postings += len(docIDs)
+		}
+		stats.Fields[name] = fieldStats
+	}
+	stats.Postings = stats.Fields[bodyField].Postings
+
+	terms := make([]TermStats, 0, len(body.postings))
+	for term, docIDs := range body.postings {
+		t := body.termStats(term)
+		stats.Tokens += t.CollectionFrequency
+		terms = append(terms, t)
+		stats.PostingLengths = addToBucket(stats.PostingLengths, len(docIDs))
+	}
+	sort.Sort(byDocumentFrequency(terms))
+	if top < 0 {
+		stats.TopTerms = terms[:0]
+	} else if top < len(terms) {
+		stats.TopTerms = terms[:top]
+	} else {
+		stats.TopTerms = terms
+	}
+	stats.Heaps = i.heapsFit()
+	stats.Zipf = zipfFit(terms)
+	stats.Cache = i.CacheStats()
+	return stats
+}
+
+// termStats returns the document and collection frequencies of term. Fields
+// indexed without positions count one occurrence per document.
+func (f *field) termStats(term string) TermStats {
+	docIDs, positions := f.lookup(term)
+	stats := TermStats{Term: term, DocumentFrequency: len(docIDs)}
+	if positions != nil {
+		for _, occurrences := range positions {
+			stats.CollectionFrequency += len(occurrences)
+		}
+	} else {
+		stats.CollectionFrequency = stats.DocumentFrequency
+	}
+	return stats
+}
+
+// addToBucket counts a posting list of the passed length in buckets, whose
+// bounds are powers of two: 1, 2-3, 4-7 and so on. Buckets are created as
+// needed and kept in order.
+func addToBucket(buckets []LengthBucket, length int) []LengthBucket {
+	min := 1
+	for min*2 <= length {
+		min *= 2
+	}
+	k := sort.Search(len(buckets), func(k int) bool { return buckets[k].Min >= min })
+	if k == len(buckets) || buckets[k].Min != min {
+		buckets = append(buckets, LengthBucket{})
+		copy(buckets[k+1:], buckets[k:])
+		buckets[k] = LengthBucket{Min: min, Max: 2*min - 1}
+	}
+	buckets[k].Terms++
+	return buckets
+}
+
+// heapsFit fits Heaps' law to the growth of the vocabulary of the body as
+// documents are added in docID order
+func (i *Indexer) heapsFit() *PowerLawFit {
+	body := i.fields[bodyField]
+	tokens := make(map[int]int)
+	newTerms := make(map[int]int)
+	for term, docIDs := range body.postings {
+		newTerms[docIDs[0]]++
+		for docID, occurrences := range body.positions[term] {
+			tokens[docID] += len(occurrences)
+		}
+	}
+	docIDs := make([]int, 0, len(tokens))
+	for docID := range tokens {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	var xs, ys []float64
+	seenTokens, seenTerms := 0, 0
+	for _, docID := range docIDs {
+		seenTokens += tokens[docID]
+		seenTerms += newTerms[docID]
+		xs = append(xs, float64(seenTokens))
+		ys = append(ys, float64(seenTerms))
+	}
+	return fitPowerLaw(xs, ys)
+}
+
+// zipfFit fits Zipf's law to the collection frequencies of terms
+func zipfFit(terms []TermStats) *PowerLawFit {
+	frequencies := make([]int, len(terms))
+	for k, t := range terms {
+		frequencies[k] = t.CollectionFrequency
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(frequencies)))
+	var xs, ys []float64
+	for k, frequency := range frequencies {
+		if frequency > 0 {
+			xs = append(xs, float64(k+1))
+			ys = append(ys, float64(frequency))
+		}
+	}
+	fit := fitPowerLaw(xs, ys)
+	if fit != nil {
+		fit.Exponent = -fit.Exponent
+	}
+	return fit
+}
+
+// fitPowerLaw fits y = c * x^e to the passed points, which must be
+// positive, by linear least squares on their logarithms. It returns nil
+// unless there are at least two distinct values of x.
+func fitPowerLaw(xs, ys []float64) *PowerLawFit {
+	n := float64(len(xs))
+	var sumX, sumY, sumXX, sumXY float64
+	for k := range xs {
+		x, y := math.Log(xs[k]), math.Log(ys[k])
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	denominator := n*sumXX - sumX*sumX
+	if len(xs) < 2 || denominator <= 1e-12 {
+		return nil
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+	var residual, total float64
+	meanY := sumY / n
+	for k := range xs {
+		y := math.Log(ys[k])
+		predicted := intercept + slope*math.Log(xs[k])
+		residual += (y - predicted) * (y - predicted)
+		total += (y - meanY) * (y - meanY)
+	}
+	r2 := 1.0
+	if total > 0 {
+		r2 = 1 - residual/total
+	}
+	return &PowerLawFit{Coefficient: math.Exp(intercept), Exponent: slope, R2: r2}
+}
+
+// byDocumentFrequency sorts terms by decreasing document frequency, then
+// by decreasing collection frequency, then alphabetically
+type byDocumentFrequency []TermStats
+
+func (s byDocumentFrequency) Len() int      { return len(s) }
+func (s byDocumentFrequency) Swap(a, b int) { s[a], s[b] = s[b], s[a] }
+func (s byDocumentFrequency) Less(a, b int) bool {
+	if s[a].DocumentFrequency != s[b].DocumentFrequency {
+		return s[a].DocumentFrequency > s[b].DocumentFrequency
+	}
+	if s[a].CollectionFrequency != s[b].CollectionFrequency {
+		return s[a].CollectionFrequency > s[b].CollectionFrequency
+	}
+	return s[a].Term < s[b].Term
+}
@@ -0,0 +1,84 @@
+package invertedindex
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Tests for the statistics of an index
+
+func TestStatsCounts(t *testing.T) {
+	stats := setUpIndexer(t, IndexerFlags{Recursive: true}, fieldpath).Stats(DefaultTopTerms)
+	if stats.Documents != 3 || stats.Terms != 11 || stats.Postings != 14 || stats.Tokens != 14 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	expected := []LengthBucket{{Min: 1, Max: 1, Terms: 8}, {Min: 2, Max: 3, Terms: 3}}
+	if !reflect.DeepEqual(stats.PostingLengths, expected) {
+		t.Errorf("Expected posting lengths: %v, actual: %v", expected, stats.PostingLengths)
+	}
+	if stats.Fields[bodyField].Terms != stats.Terms || stats.Fields[extField].Terms != 3 {
+		t.Errorf("Unexpected field stats: %v", stats.Fields)
+	}
+	if stats.Heaps == nil || stats.Zipf == nil {
+		t.Error("Expected fits of Heaps' and Zipf's laws")
+	}
+}
+
+func TestStatsTopTerms(t *testing.T) {
+	stats := setUpIndexer(t, IndexerFlags{}, snippetpath).Stats(3)
+	expected := []TermStats{
+		{Term: "quick", DocumentFrequency: 1, CollectionFrequency: 3},
+		{Term: "The", DocumentFrequency: 1, CollectionFrequency: 2},
+		{Term: "brown", DocumentFrequency: 1, CollectionFrequency: 2},
+	}
+	if !reflect.DeepEqual(stats.TopTerms, expected) {
+		t.Errorf("Expected top terms: %v, actual: %v", expected, stats.TopTerms)
+	}
+	if stats.Heaps != nil {
+		t.Errorf("Expected no fit of Heaps' law to a single document, got: %v", stats.Heaps)
+	}
+	if stats := setUpIndexer(t, IndexerFlags{}, snippetpath).Stats(-1); len(stats.TopTerms) != 0 {
+		t.Errorf("Expected no top terms for a negative top, actual: %v", stats.TopTerms)
+	}
+}
+
+func TestAddToBucket(t *testing.T) {
+	var buckets []LengthBucket
+	for _, length := range []int{5, 1, 7, 2, 1, 16} {
+		buckets = addToBucket(buckets, length)
+	}
+	expected := []LengthBucket{{Min: 1, Max: 1, Terms: 2}, {Min: 2, Max: 3, Terms: 1},
+		{Min: 4, Max: 7, Terms: 2}, {Min: 16, Max: 31, Terms: 1}}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("Expected buckets: %v, actual: %v", expected, buckets)
+	}
+}
+
+func TestFitPowerLaw(t *testing.T) {
+	xs := []float64{1, 2, 4, 8, 16}
+	ys := make([]float64, len(xs))
+	for k, x := range xs {
+		ys[k] = 3 * math.Pow(x, 0.5)
+	}
+	fit := fitPowerLaw(xs, ys)
+	if fit == nil || !closeTo(fit.Coefficient, 3) || !closeTo(fit.Exponent, 0.5) || !closeTo(fit.R2, 1) {
+		t.Errorf("Expected fit of 3 * x^0.5, got: %+v", fit)
+	}
+	if fit := fitPowerLaw([]float64{2, 2}, []float64{1, 3}); fit != nil {
+		t.Errorf("Expected no fit without distinct x values, got: %+v", fit)
+	}
+}
+
+func TestZipfFit(t *testing.T) {
+	terms := []TermStats{{Term: "c", CollectionFrequency: 20}, {Term: "a", CollectionFrequency: 60},
+		{Term: "b", CollectionFrequency: 30}, {Term: "d", CollectionFrequency: 15}}
+	fit := zipfFit(terms)
+	if fit == nil || !closeTo(fit.Coefficient, 60) || !closeTo(fit.Exponent, 1) {
+		t.Errorf("Expected fit of 60 * rank^-1, got: %+v", fit)
+	}
+}
+
+func closeTo(actual, expected float64) bool {
+	return math.Abs(actual-expected) < 1e-9
+}
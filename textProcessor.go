@@ -10,8 +10,22 @@ package invertedindex
 import (
 	"bytes"
 	"regexp"
+	"unicode"
+	"unicode/utf8"
 )
 
+// A Token is a term along with where it was found in the original text
+type Token struct {
+	Term []byte
+	// Position is the index of the token among all the tokens of the text
+	Position int
+	// Offset is the byte offset of the start of the token in the text
+	Offset int
+	// Line and Column locate the start of the token in the text; both count
+	// from 1 and Column counts bytes from the start of the line
+	Line, Column int
+}
+
 // ExtractTerms takes a byte slice of a text file and parses it into
 // a slice of term slices. To do so it performs tokenization and
 // normalization.
@@ -19,6 +33,12 @@ func ExtractTerms(file []byte) [][]byte {
 	return tokenize(file)
 }
 
+// ExtractTokens is like ExtractTerms, but also records the position and byte
+// offset of every term so that they can be traced back to the original text.
+func ExtractTokens(file []byte) []Token {
+	return tokenizeWithOffsets(file)
+}
+
 // tokenize tokenizes a byte slice of text by whitespace and returns
 // a slice of slice tokens of terms in the text. If the byte slice is
 // empty or is only whitespace, returns an empty slice.
@@ -36,3 +56,35 @@ func normalize(tokens [][]byte) [][]byte {
 	}
 	return tokens
 }
+
+// tokenizeWithOffsets splits a byte slice of text by whitespace exactly as
+// tokenize does, but returns Tokens recording where each term was found.
+func tokenizeWithOffsets(file []byte) []Token {
+	tokens := []Token{}
+	start := -1
+	line, lineStart := 1, 0
+	token := func(end int) Token {
+		return Token{Term: file[start:end], Position: len(tokens), Offset: start,
+			Line: line, Column: start - lineStart + 1}
+	}
+	for offset := 0; offset < len(file); {
+		r, size := utf8.DecodeRune(file[offset:])
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				tokens = append(tokens, token(offset))
+				start = -1
+			}
+			if r == '\n' {
+				line++
+				lineStart = offset + size
+			}
+		} else if start < 0 {
+			start = offset
+		}
+		offset += size
+	}
+	if start >= 0 {
+		tokens = append(tokens, token(len(file)))
+	}
+	return tokens
+}
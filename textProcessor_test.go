@@ -125,3 +125,60 @@ func TestRemoveMultiplePunctuationUpperCase(t *testing.T) {
 	expected := [][]byte{[]byte("usas")}
 	assertEqualTokenSlices(t, normalize(tokens), expected)
 }
+
+// Token Offset Tests
+
+// checks that tokens have the expected terms, positions and byte offsets
+func assertEqualTokens(t *testing.T, a []Token, terms []string, offsets []int) {
+	if len(a) != len(terms) {
+		t.Fatalf("Expected number of tokens: %d Actual: %d", len(terms), len(a))
+	}
+	for i, token := range a {
+		if string(token.Term) != terms[i] || token.Position != i || token.Offset != offsets[i] {
+			t.Errorf("Expected token %q at position %d offset %d, Actual: %q at position %d offset %d",
+				terms[i], i, offsets[i], token.Term, token.Position, token.Offset)
+		}
+	}
+}
+
+func TestTokenOffsetsEmptyFile(t *testing.T) {
+	assertEqualTokens(t, tokenizeWithOffsets([]byte{}), []string{}, []int{})
+}
+
+func TestTokenOffsetsWhiteSpace(t *testing.T) {
+	assertEqualTokens(t, tokenizeWithOffsets([]byte(" \t\n")), []string{}, []int{})
+}
+
+func TestTokenOffsetsMultipleTerms(t *testing.T) {
+	bytes := []byte("  hi there\n\tagain")
+	assertEqualTokens(t, tokenizeWithOffsets(bytes), []string{"hi", "there", "again"}, []int{2, 5, 12})
+}
+
+func TestTokenOffsetsMultiByteRunes(t *testing.T) {
+	bytes := []byte("ñandu über")
+	assertEqualTokens(t, tokenizeWithOffsets(bytes), []string{"ñandu", "über"}, []int{0, 7})
+}
+
+func TestTokenOffsetsMatchTokenize(t *testing.T) {
+	bytes := []byte(" alpha  beta\r\ngamma delta\xff epsilon ")
+	tokens := tokenizeWithOffsets(bytes)
+	terms := make([][]byte, len(tokens))
+	for i, token := range tokens {
+		terms[i] = token.Term
+	}
+	assertEqualTokenSlices(t, terms, tokenize(bytes))
+}
+
+func TestTokenLinesAndColumns(t *testing.T) {
+	tokens := tokenizeWithOffsets([]byte("alpha beta\n  gamma\r\n\ndelta"))
+	expected := [][2]int{{1, 1}, {1, 7}, {2, 3}, {4, 1}}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected number of tokens: %d Actual: %d", len(expected), len(tokens))
+	}
+	for i, token := range tokens {
+		if token.Line != expected[i][0] || token.Column != expected[i][1] {
+			t.Errorf("Expected token %q at %d:%d, Actual: %d:%d", token.Term,
+				expected[i][0], expected[i][1], token.Line, token.Column)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package invertedindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+)
+
+// maxProblems is how many problems a VerifyReport lists at most
+const maxProblems = 100
+
+// derivedFiles are the files of a commit that can be rebuilt from the
+// others: the .idx files, the counts of the meta file, and the document
+// frequencies of the terms file. The settings of the fields in the meta
+// file cannot be, so a meta file that is missing or unreadable is not
+// repairable.
+var derivedFiles = map[string]bool{termIndexFile: true, docIndexFile: true, metaFile: true}
+
+// Problem is an inconsistency found in an index on disk
+type Problem struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+	// whether the problem lies in a structure derived from the rest of the
+	// index, which RepairIndex can rebuild
+	Derived bool `json:"derived"`
+}
+
+// VerifyReport is what VerifyIndex found checking the latest commit of an
+// index on disk
+type VerifyReport struct {
+	Generation int       `json:"generation"`
+	Documents  int       `json:"documents"`
+	Terms      int       `json:"terms"`
+	Postings   int       `json:"postings"`
+	Problems   []Problem `json:"problems"`
+	// problems found beyond the first maxProblems, and whether they were
+	// all derived
+	More        int  `json:"more,omitempty"`
+	MoreDerived bool `json:"-"`
+}
+
+// OK reports whether no problem was found
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Repairable reports whether every problem found lies in a derived
+// structure, so that RepairIndex can fix it
+func (r VerifyReport) Repairable() bool {
+	for _, p := range r.Problems {
+		if !p.Derived {
+			return false
+		}
+	}
+	return r.More == 0 || r.MoreDerived
+}
+
+// verifier checks a commit of an index on disk, keeping what it reads for
+// rebuilding the derived structures
+type verifier struct {
+	dir      string
+	manifest manifest
+	files    map[string][]byte
+	unmap    []func() error
+	meta     indexMeta
+	report   VerifyReport
+	// the term entries with their document frequencies as counted, and one
+	// more than the offset of the record of every docID, or zero
+	entries    []termEntry
+	docOffsets []uint64
+	// whether every document and every term entry was read, without which
+	// nothing can be rebuilt
+	docsChecked, termsChecked bool
+}
+
+// VerifyIndex checks that the latest commit of the index in dir is
+// consistent: that its files match their checksums, that the term entries
+// are sorted and their postings decode, with docIDs strictly increasing and
+// positions sorted, that every docID belongs to a document, and that the
+// .idx files, document frequencies and document count agree with the rest.
+// An error is only returned if there is no commit to check.
+func VerifyIndex(dir string) (VerifyReport, error) {
+	v, err := verifyCommit(dir)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	v.close()
+	return v.report, nil
+}
+
+// RepairIndex verifies the index in dir and, if the only problems lie in
+// derived structures, writes a new commit with them rebuilt from the rest
+// of the index. It returns the report of the commit written, or of the
+// latest one if it did not need or could not have repairs.
+func RepairIndex(dir string) (VerifyReport, error) {
+	v, err := verifyCommit(dir)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	defer v.close()
+	if v.report.OK() {
+		return v.report, nil
+	}
+	if !v.report.Repairable() {
+		return v.report, fmt.Errorf("commit %d: only derived structures can be repaired",
+			v.report.Generation)
+	}
+	if !v.docsChecked || !v.termsChecked {
+		return v.report, fmt.Errorf("commit %d: cannot repair without reading every document "+
+			"and term", v.report.Generation)
+	}
+	c, err := newCommitWriter(dir)
+	if err != nil {
+		return v.report, err
+	}
+	if err := v.rebuild(c); err != nil {
+		c.abort()
+		return v.report, err
+	}
+	removeStaleCommits(dir)
+	return VerifyIndex(dir)
+}
+
+func verifyCommit(dir string) (*verifier, error) {
+	generations, err := commitGenerations(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(generations) == 0 {
+		return nil, fmt.Errorf("%s: no commit of an index", dir)
+	}
+	v := &verifier{dir: dir, files: make(map[string][]byte),
+		report: VerifyReport{Generation: generations[0], Problems: []Problem{}}}
+	if v.manifest, err = readManifest(dir, generations[0]); err != nil {
+		v.problem(manifestPath(dir, generations[0]), false, "%s", err)
+		return v, nil
+	}
+	for _, name := range []string{termsFile, termIndexFile, postingsFile, docsFile, docIndexFile, metaFile} {
+		v.checkFile(name)
+	}
+	if !v.checkMeta() {
+		return v, nil
+	}
+	if v.checkDocs() {
+		v.checkTerms()
+	}
+	return v, nil
+}
+
+// problem records a problem with the named file
+func (v *verifier) problem(file string, derived bool, format string, args ...interface{}) {
+	r := &v.report
+	if len(r.Problems) < maxProblems {
+		r.Problems = append(r.Problems, Problem{File: file, Message: fmt.Sprintf(format, args...),
+			Derived: derived})
+		return
+	}
+	if r.More == 0 {
+		r.MoreDerived = true
+	}
+	r.More++
+	r.MoreDerived = r.MoreDerived && derived
+}
+
+func (v *verifier) close() {
+	for _, unmap := range v.unmap {
+		unmap()
+	}
+	v.unmap = nil
+}
+
+// checkFile maps the named file of the commit and checks its size and
+// checksums
+func (v *verifier) checkFile(name string) {
+	// the meta file is only derived in part, so it must be whole to be
+	// rebuilt
+	derived := derivedFiles[name] && name != metaFile
+	sum, ok := v.manifest.file(name)
+	if !ok {
+		v.problem(name, false, "missing from the manifest")
+		return
+	}
+	data, unmap, err := mmapFile(filepath.Join(generationDir(v.dir, v.manifest.Generation), name))
+	if err != nil {
+		v.problem(name, derived, "%s", err)
+		return
+	}
+	v.unmap = append(v.unmap, unmap)
+	v.files[name] = data
+	if int64(len(data)) != sum.Size {
+		v.problem(name, derived, "%d bytes rather than %d", len(data), sum.Size)
+		return
+	}
+	if crc32.ChecksumIEEE(data) == sum.CRC32 {
+		return
+	}
+	size := v.manifest.BlockSize
+	for b := 0; b*size < len(data); b++ {
+		end := (b + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		if b >= len(sum.Blocks) || crc32.ChecksumIEEE(data[b*size:end]) != sum.Blocks[b] {
+			v.problem(name, derived, "block %d does not match its checksum", b)
+		}
+	}
+}
+
+// checkMeta reads the meta file, telling whether the rest can be checked
+func (v *verifier) checkMeta() bool {
+	data, ok := v.files[metaFile]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(data, &v.meta); err != nil {
+		v.problem(metaFile, false, "%s", err)
+		return false
+	}
+	return true
+}
+
+// checkDocs checks the records of the docs file against the docs.idx and
+// meta files, telling whether the terms can be checked against them
+func (v *verifier) checkDocs() bool {
+	data, ok := v.files[docsFile]
+	if !ok {
+		return false
+	}
+	v.docOffsets = make([]uint64, v.meta.NextDocID)
+	previous := -1
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			v.problem(docsFile, false, "record at byte %d is not terminated", offset)
+			return false
+		}
+		var record docRecord
+		if err := json.Unmarshal(data[offset:offset+end], &record); err != nil {
+			v.problem(docsFile, false, "record at byte %d: %s", offset, err)
+			return false
+		}
+		if record.ID <= previous {
+			v.problem(docsFile, false, "docID %d follows docID %d", record.ID, previous)
+			return false
+		}
+		previous = record.ID
+		if record.ID >= v.meta.NextDocID {
+			v.problem(metaFile, true, "docID %d is not below nextDocID %d", record.ID, v.meta.NextDocID)
+			v.docOffsets = append(v.docOffsets, make([]uint64, record.ID+1-len(v.docOffsets))...)
+		}
+		v.docOffsets[record.ID] = uint64(offset + 1)
+		v.report.Documents++
+		offset += end + 1
+	}
+	if v.report.Documents != v.meta.Documents {
+		v.problem(metaFile, true, "%d documents rather than %d", v.meta.Documents, v.report.Documents)
+	}
+	v.docsChecked = true
+
+	index, ok := v.files[docIndexFile]
+	if !ok {
+		return true
+	}
+	if len(index) != len(docIndexMagic)+8*v.meta.NextDocID ||
+		!bytes.HasPrefix(index, []byte(docIndexMagic)) {
+		v.problem(docIndexFile, true, "does not match the docs file")
+		return true
+	}
+	for docID := 0; docID < v.meta.NextDocID; docID++ {
+		offset := binary.LittleEndian.Uint64(index[len(docIndexMagic)+8*docID:])
+		if offset != v.docOffsets[docID] {
+			v.problem(docIndexFile, true, "offset of docID %d does not match the docs file", docID)
+		}
+	}
+	return true
+}
+
+// checkTerms checks every term entry and its postings, then the terms.idx
+// file
+func (v *verifier) checkTerms() {
+	data, ok := v.files[termsFile]
+	postings, ok2 := v.files[postingsFile]
+	if !ok || !ok2 {
+		return
+	}
+	if !bytes.HasPrefix(data, []byte(termsMagic)) {
+		v.problem(termsFile, false, "not an index file of version %s", termsMagic)
+		return
+	}
+	if !bytes.HasPrefix(postings, []byte(postingsMagic)) {
+		v.problem(postingsFile, false, "not an index file of version %s", postingsMagic)
+		return
+	}
+	var offsets []uint64
+	var previous termEntry
+	for offset := len(termsMagic); offset < len(data); {
+		e, n, err := parseTermEntry(data[offset:])
+		if err != nil {
+			v.problem(termsFile, false, "entry at byte %d: %s", offset, err)
+			return
+		}
+		if len(offsets) > 0 && (e.field < previous.field ||
+			e.field == previous.field && e.term <= previous.term) {
+			v.problem(termsFile, false, "%s:%s follows %s:%s", e.field, e.term, previous.field,
+				previous.term)
+		}
+		if len(offsets) == 0 || e.field != previous.field {
+			if _, ok := v.meta.Fields[e.field]; !ok {
+				v.problem(metaFile, false, "field %s of the terms file is not listed", e.field)
+			}
+		}
+		offsets = append(offsets, uint64(offset))
+		previous = e
+		offset += n
+		v.checkPostings(&e, postings)
+		v.entries = append(v.entries, e)
+	}
+	v.report.Terms = len(v.entries)
+	v.termsChecked = true
+
+	index, ok := v.files[termIndexFile]
+	if !ok {
+		return
+	}
+	if len(index) != len(termIndexMagic)+8*len(offsets) || !bytes.HasPrefix(index, []byte(termIndexMagic)) {
+		v.problem(termIndexFile, true, "does not match the terms file")
+		return
+	}
+	for k, offset := range offsets {
+		if binary.LittleEndian.Uint64(index[len(termIndexMagic)+8*k:]) != offset {
+			v.problem(termIndexFile, true, "offset of entry %d does not match the terms file", k)
+		}
+	}
+}
+
+// checkPostings checks the postings of a term entry, and sets its document
+// frequency to the one counted
+func (v *verifier) checkPostings(e *termEntry, postings []byte) {
+	name := e.field + ":" + e.term
+	end := e.offset + e.length
+	if e.offset < uint64(len(postingsMagic)) || end < e.offset || end > uint64(len(postings)) {
+		v.problem(termsFile, false, "postings of %s lie outside the postings file", name)
+		return
+	}
+	docIDs, occurrences, err := decodePostings(postings[e.offset:end])
+	if err != nil {
+		v.problem(postingsFile, false, "postings of %s: %s", name, err)
+		return
+	}
+	for _, docID := range docIDs {
+		if docID >= len(v.docOffsets) || v.docOffsets[docID] == 0 {
+			v.problem(postingsFile, false, "postings of %s: no document with docID %d", name, docID)
+		}
+		list := occurrences[docID]
+		for k := 1; k < len(list); k++ {
+			if list[k].position <= list[k-1].position {
+				v.problem(postingsFile, false, "postings of %s: positions in docID %d are not sorted",
+					name, docID)
+				break
+			}
+		}
+	}
+	if e.documentFrequency != uint64(len(docIDs)) {
+		v.problem(termsFile, true, "document frequency of %s is %d rather than %d", name,
+			e.documentFrequency, len(docIDs))
+		e.documentFrequency = uint64(len(docIDs))
+	}
+	v.report.Postings += len(docIDs)
+}
+
+// rebuild writes a commit with the files of the one verified, rebuilding
+// the derived structures
+func (v *verifier) rebuild(c *commitWriter) error {
+	tw, err := c.create(termsFile)
+	if err != nil {
+		return err
+	}
+	xw, err := c.create(termIndexFile)
+	if err != nil {
+		return err
+	}
+	if _, err := tw.WriteString(termsMagic); err != nil {
+		return err
+	}
+	if _, err := xw.WriteString(termIndexMagic); err != nil {
+		return err
+	}
+	termOffset := uint64(len(termsMagic))
+	var entry []byte
+	var scratch [8]byte
+	for _, e := range v.entries {
+		entry = appendTermEntry(entry[:0], e)
+		if _, err := tw.Write(entry); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(scratch[:], termOffset)
+		if _, err := xw.Write(scratch[:]); err != nil {
+			return err
+		}
+		termOffset += uint64(len(entry))
+	}
+
+	dw, err := c.create(docIndexFile)
+	if err != nil {
+		return err
+	}
+	if _, err := dw.WriteString(docIndexMagic); err != nil {
+		return err
+	}
+	for _, offset := range v.docOffsets {
+		binary.LittleEndian.PutUint64(scratch[:], offset)
+		if _, err := dw.Write(scratch[:]); err != nil {
+			return err
+		}
+	}
+
+	meta := v.meta
+	meta.Documents, meta.NextDocID = v.report.Documents, len(v.docOffsets)
+	contents, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := c.create(metaFile)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(contents); err != nil {
+		return err
+	}
+
+	for _, name := range []string{postingsFile, docsFile} {
+		w, err := c.create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(v.files[name]); err != nil {
+			return err
+		}
+	}
+	for len(c.open) > 0 {
+		if err := c.open[0].close(); err != nil {
+			return err
+		}
+	}
+	return c.finish()
+}
@@ -0,0 +1,259 @@
+package invertedindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests for verifying and repairing indexes on disk
+
+func TestVerifyIndex(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	indexer := setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	indexer.loadAll()
+	terms, postings := 0, 0
+	for _, f := range indexer.fields {
+		terms += len(f.postings)
+		for _, docIDs := range f.postings {
+			postings += len(docIDs)
+		}
+	}
+	report, err := VerifyIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Generation != 1 || report.Documents != 3 || report.Terms != terms ||
+		report.Postings != postings {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestVerifyMissingIndex(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	if _, err := VerifyIndex(dir); err == nil {
+		t.Error("Expected an error verifying a directory without a commit")
+	}
+}
+
+func TestRepairChecksum(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	path := filepath.Join(generationDir(dir, 1), docIndexFile)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents[len(docIndexMagic)] ^= 0xff
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, []string{
+		"docs.idx: block 0 does not match its checksum",
+		"docs.idx: offset of docID 0 does not match the docs file",
+	})
+	if !report.Repairable() {
+		t.Error("Expected the index to be repairable")
+	}
+	if report, err = RepairIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Generation != 2 {
+		t.Errorf("Expected a repaired commit, got: %+v", report)
+	}
+	opened, err := OpenIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	assertSearchResult(t, opened, "install", []int{0, 1})
+	if path, ok := opened.Path(0); !ok || filepath.Base(path) != "README.md" {
+		t.Errorf("Expected document 0 to be README.md, actual: %s", path)
+	}
+}
+
+func TestRepairRemovesStaleCommits(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	for generation := 1; generation <= 4; generation++ {
+		path := filepath.Join(generationDir(dir, generation), docIndexFile)
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[len(docIndexMagic)] ^= 0xff
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		report, err := RepairIndex(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.OK() || report.Generation != generation+1 {
+			t.Errorf("Expected commit %d to be repaired, got: %+v", generation+1, report)
+		}
+		expected := []int{generation + 1, generation}
+		if generations, _ := commitGenerations(dir); !reflect.DeepEqual(generations, expected) {
+			t.Errorf("Expected commits %v to be kept, actual: %v", expected, generations)
+		}
+	}
+}
+
+func TestRepairMissingMeta(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	setUpIndexer(t, IndexerFlags{Recursive: true, IndexDir: dir}, fieldpath)
+	if err := os.Remove(filepath.Join(generationDir(dir, 1), metaFile)); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Repairable() {
+		t.Errorf("Expected a missing meta file not to be repairable, got: %+v", report)
+	}
+	if _, err := RepairIndex(dir); err == nil {
+		t.Error("Expected repairing without a meta file to fail")
+	}
+	if generations, _ := commitGenerations(dir); len(generations) != 1 {
+		t.Errorf("Expected no commit to be written, actual: %v", generations)
+	}
+}
+
+func TestRepairDocumentFrequency(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	writeIndexCommit(t, dir, fakeIndexFiles(encodePostings(nil, []int{0, 1}, nil), 3))
+	report, err := VerifyIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, []string{"terms: document frequency of body:a is 3 rather than 2"})
+	if report, err = RepairIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Terms != 1 || report.Postings != 2 {
+		t.Errorf("Expected a repaired commit, got: %+v", report)
+	}
+}
+
+func TestVerifyPostings(t *testing.T) {
+	unsortedPositions := encodePostings(nil, []int{0}, map[int][]occurrence{0: {{position: 3}, {position: 1}}})
+	tests := []struct {
+		postings []byte
+		problem  string
+	}{
+		{[]byte{2, 1, 0, 0, 0}, "postings: postings of body:a: docIDs not strictly increasing"},
+		{unsortedPositions, "postings: postings of body:a: positions in docID 0 are not sorted"},
+		{encodePostings(nil, []int{0, 5}, nil), "postings: postings of body:a: no document with docID 5"},
+		{[]byte{1, 0}, "postings: postings of body:a: corrupt index file"},
+	}
+	for _, test := range tests {
+		dir := tempIndexDir(t)
+		writeIndexCommit(t, dir, fakeIndexFiles(test.postings, 2))
+		report, err := VerifyIndex(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) == 0 || report.Problems[0].File+": "+report.Problems[0].Message != test.problem {
+			t.Errorf("Expected the problem: %s, actual: %+v", test.problem, report.Problems)
+		}
+		if _, err := RepairIndex(dir); err == nil {
+			t.Errorf("Expected %q not to be repairable", test.problem)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestVerifyMissingDocument(t *testing.T) {
+	dir := tempIndexDir(t)
+	defer os.RemoveAll(dir)
+	files := fakeIndexFiles(encodePostings(nil, []int{0, 1}, nil), 2)
+	files[docsFile] = files[docsFile][:bytes.IndexByte(files[docsFile], '\n')+1]
+	writeIndexCommit(t, dir, files)
+	report, err := VerifyIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, []string{
+		"meta.json: 2 documents rather than 1",
+		"docs.idx: offset of docID 1 does not match the docs file",
+		"postings: postings of body:a: no document with docID 1",
+	})
+	if report.Repairable() {
+		t.Error("Expected a missing document not to be repairable")
+	}
+}
+
+// fakeIndexFiles returns the files of an index of two documents and a single
+// term of the body with the passed postings and document frequency
+func fakeIndexFiles(postings []byte, documentFrequency uint64) map[string][]byte {
+	e := termEntry{field: bodyField, term: "a", offset: uint64(len(postingsMagic)),
+		length: uint64(len(postings)), documentFrequency: documentFrequency}
+	docs := "{\"id\":0,\"path\":\"a.txt\"}\n{\"id\":1,\"path\":\"b.txt\"}\n"
+	return map[string][]byte{
+		termsFile:     appendTermEntry([]byte(termsMagic), e),
+		termIndexFile: appendUint64s([]byte(termIndexMagic), uint64(len(termsMagic))),
+		postingsFile:  append([]byte(postingsMagic), postings...),
+		docsFile:      []byte(docs),
+		docIndexFile:  appendUint64s([]byte(docIndexMagic), 1, uint64(strings.Index(docs, "\n")+2)),
+		metaFile:      []byte(`{"documents": 2, "nextDocID": 2, "fields": {"body": {"foldCase": false}}}`),
+	}
+}
+
+func appendUint64s(buf []byte, values ...uint64) []byte {
+	var scratch [8]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(scratch[:], v)
+		buf = append(buf, scratch[:]...)
+	}
+	return buf
+}
+
+// writeIndexCommit writes a commit of the passed files to dir
+func writeIndexCommit(t *testing.T, dir string, files map[string][]byte) {
+	c, err := newCommitWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		w, err := c.create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertProblems(t *testing.T, report VerifyReport, expected []string) {
+	actual := []string{}
+	for _, p := range report.Problems {
+		actual = append(actual, p.File+": "+p.Message)
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\nactual:\n%s", strings.Join(expected, "\n"),
+			strings.Join(actual, "\n"))
+	}
+}